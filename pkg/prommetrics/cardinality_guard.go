@@ -0,0 +1,102 @@
+/*
+Copyright 2022 The KEDA Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package prommetrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// CardinalityGuard caps the number of distinct label combinations a scaler records for a
+// metric. Combinations beyond the limit are dropped. A guard is meant to outlive the scalers
+// rebuilt for a trigger, so the cap holds across them.
+type CardinalityGuard struct {
+	lock      sync.Mutex
+	limit     int
+	seen      map[string][]string
+	triggered bool
+}
+
+// NewCardinalityGuard creates a guard allowing up to limit label combinations
+func NewCardinalityGuard(limit int) *CardinalityGuard {
+	return &CardinalityGuard{
+		limit: limit,
+		seen:  map[string][]string{},
+	}
+}
+
+// SetLimit changes the number of label combinations allowed. The combinations already allowed
+// are kept.
+func (g *CardinalityGuard) SetLimit(limit int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.limit = limit
+	g.resetTriggered()
+}
+
+// Allow reports whether the label combination can be recorded. Combinations that were already
+// allowed always are, so the series recorded so far keep being updated.
+func (g *CardinalityGuard) Allow(labelValues ...string) bool {
+	key := strings.Join(labelValues, "\x00")
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if _, ok := g.seen[key]; ok {
+		return true
+	}
+	if len(g.seen) < g.limit {
+		g.seen[key] = labelValues
+		return true
+	}
+	if !g.triggered {
+		g.triggered = true
+		log.Info("Cardinality limit reached, dropping metrics with new labels", "limit", g.limit, "labels", labelValues)
+	}
+	return false
+}
+
+// Forget frees the slot of a label combination whose series was deleted
+func (g *CardinalityGuard) Forget(labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	delete(g.seen, key)
+	g.resetTriggered()
+}
+
+// resetTriggered warns again the next time the limit is reached, once there is room under it
+func (g *CardinalityGuard) resetTriggered() {
+	if len(g.seen) < g.limit {
+		g.triggered = false
+	}
+}
+
+// Allowed returns the label combinations allowed so far
+func (g *CardinalityGuard) Allowed() [][]string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	allowed := make([][]string, 0, len(g.seen))
+	for _, labelValues := range g.seen {
+		allowed = append(allowed, labelValues)
+	}
+	return allowed
+}
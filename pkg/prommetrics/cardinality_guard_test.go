@@ -0,0 +1,71 @@
+package prommetrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	guard := NewCardinalityGuard(2)
+
+	assert.True(t, guard.Allow("ns", "subject", "client-1"))
+	assert.True(t, guard.Allow("ns", "subject", "client-2"))
+	assert.False(t, guard.Allow("ns", "subject", "client-3"))
+
+	// combinations under the cap keep being recorded
+	assert.True(t, guard.Allow("ns", "subject", "client-1"))
+	assert.True(t, guard.Allow("ns", "subject", "client-2"))
+	assert.False(t, guard.Allow("ns", "subject", "client-3"))
+}
+
+func TestCardinalityGuardUnderLimit(t *testing.T) {
+	guard := NewCardinalityGuard(10)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, guard.Allow("ns", "subject", "client-1"))
+		assert.True(t, guard.Allow("ns", "subject", "client-2"))
+	}
+	assert.False(t, guard.triggered)
+}
+
+func TestCardinalityGuardForget(t *testing.T) {
+	guard := NewCardinalityGuard(1)
+
+	assert.True(t, guard.Allow("ns", "subject", "client-1"))
+	assert.False(t, guard.Allow("ns", "subject", "client-2"))
+	assert.Equal(t, [][]string{{"ns", "subject", "client-1"}}, guard.Allowed())
+
+	// a forgotten combination frees its slot
+	guard.Forget("ns", "subject", "client-1")
+	assert.Empty(t, guard.Allowed())
+	assert.True(t, guard.Allow("ns", "subject", "client-2"))
+
+	guard.SetLimit(2)
+	assert.True(t, guard.Allow("ns", "subject", "client-1"))
+}
+
+func TestCardinalityGuardWarnsAgain(t *testing.T) {
+	guard := NewCardinalityGuard(1)
+
+	assert.True(t, guard.Allow("ns", "subject", "client-1"))
+	assert.False(t, guard.Allow("ns", "subject", "client-2"))
+	assert.True(t, guard.triggered)
+
+	// dropping below the limit rearms the warning
+	guard.Forget("ns", "subject", "client-1")
+	assert.False(t, guard.triggered)
+	assert.True(t, guard.Allow("ns", "subject", "client-2"))
+	assert.False(t, guard.Allow("ns", "subject", "client-3"))
+	assert.True(t, guard.triggered)
+
+	guard.SetLimit(2)
+	assert.False(t, guard.triggered)
+	assert.True(t, guard.Allow("ns", "subject", "client-3"))
+	assert.False(t, guard.Allow("ns", "subject", "client-4"))
+	assert.True(t, guard.triggered)
+
+	// a limit still reached keeps it quiet
+	guard.SetLimit(1)
+	assert.True(t, guard.triggered)
+}
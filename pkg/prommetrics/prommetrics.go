@@ -18,6 +18,8 @@ package prommetrics
 
 import (
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -26,6 +28,12 @@ import (
 
 var log = logf.Log.WithName("prometheus_server")
 
+var (
+	// firstPolls holds the triggers of each ScaledObject whose first poll was recorded
+	firstPolls     = make(map[string]map[int]bool)
+	firstPollsLock sync.Mutex
+)
+
 const (
 	ClusterTriggerAuthenticationResource = "cluster_trigger_authentication"
 	TriggerAuthenticationResource        = "trigger_authentication"
@@ -91,6 +99,55 @@ var (
 		},
 		[]string{"type", "namespace"},
 	)
+
+	scalerSubscriberLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: DefaultPromMetricsNamespace,
+			Subsystem: "scaler",
+			Name:      "subscriber_lag",
+			Help:      "Lag of the subscribers of a scaler",
+		},
+		[]string{"namespace", "scaledObject", "scaler", "subject", "subscriber"},
+	)
+
+	scalerThreshold = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: DefaultPromMetricsNamespace,
+			Subsystem: "scaler",
+			Name:      "threshold",
+			Help:      "Effective threshold of the metric of a scaler",
+		},
+		metricLabels,
+	)
+
+	scalerMonitorRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: DefaultPromMetricsNamespace,
+			Subsystem: "scaler",
+			Name:      "monitor_requests_total",
+			Help:      "Requests of a scaler to the monitoring endpoint of its source, by outcome",
+		},
+		[]string{"namespace", "scaledObject", "scaler", "outcome"},
+	)
+
+	scalerConstructionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: DefaultPromMetricsNamespace,
+			Subsystem: "scaler",
+			Name:      "construction_duration_seconds",
+			Help:      "Time taken to create a scaler",
+		},
+		[]string{"scaler"},
+	)
+	scalerFirstPollDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: DefaultPromMetricsNamespace,
+			Subsystem: "scaler",
+			Name:      "first_poll_duration_seconds",
+			Help:      "Time between the creation of a scaler and its first successful poll",
+		},
+		[]string{"scaler"},
+	)
 )
 
 func init() {
@@ -101,6 +158,12 @@ func init() {
 
 	metrics.Registry.MustRegister(triggerTotalsGaugeVec)
 	metrics.Registry.MustRegister(crdTotalsGaugeVec)
+
+	metrics.Registry.MustRegister(scalerSubscriberLag)
+	metrics.Registry.MustRegister(scalerThreshold)
+	metrics.Registry.MustRegister(scalerMonitorRequests)
+	metrics.Registry.MustRegister(scalerConstructionDuration)
+	metrics.Registry.MustRegister(scalerFirstPollDuration)
 }
 
 // RecordScalerMetric create a measurement of the external metric used by the HPA
@@ -138,6 +201,71 @@ func RecordScaledObjectError(namespace string, scaledObject string, err error) {
 	}
 }
 
+// RecordScalerSubscriberLag measures the lag of a subscriber of a scaler, unless the guard caps
+// the number of subscribers already recorded
+func RecordScalerSubscriberLag(guard *CardinalityGuard, namespace string, scaledObject string, scaler string, subject string, subscriber string, lag float64) {
+	if !guard.Allow(namespace, scaledObject, scaler, subject, subscriber) {
+		return
+	}
+	scalerSubscriberLag.WithLabelValues(namespace, scaledObject, scaler, subject, subscriber).Set(lag)
+}
+
+// DeleteScalerSubscriberLags deletes the subscriber lags recorded through the guard, except those
+// for which keep returns true, and frees their slots in the guard. A nil keep deletes them all.
+func DeleteScalerSubscriberLags(guard *CardinalityGuard, keep func(subject string, subscriber string) bool) {
+	for _, labelValues := range guard.Allowed() {
+		// the label values are in the order of RecordScalerSubscriberLag
+		if keep != nil && keep(labelValues[3], labelValues[4]) {
+			continue
+		}
+		scalerSubscriberLag.DeleteLabelValues(labelValues...)
+		guard.Forget(labelValues...)
+	}
+}
+
+// RecordScalerThreshold measures the effective threshold of the external metric used by the HPA,
+// so it can be compared to the metric value
+func RecordScalerThreshold(namespace string, scaledObject string, scaler string, scalerIndex int, metric string, threshold float64) {
+	scalerThreshold.With(getLabels(namespace, scaledObject, scaler, scalerIndex, metric)).Set(threshold)
+}
+
+// RecordScalerMonitorRequest counts a request of a scaler to the monitoring endpoint of its
+// source, labeled by its outcome
+func RecordScalerMonitorRequest(namespace string, scaledObject string, scaler string, outcome string) {
+	scalerMonitorRequests.WithLabelValues(namespace, scaledObject, scaler, outcome).Inc()
+}
+
+// RecordScalerConstructionDuration measures the time taken to create a scaler of the given type
+func RecordScalerConstructionDuration(scaler string, duration time.Duration) {
+	scalerConstructionDuration.WithLabelValues(scaler).Observe(duration.Seconds())
+}
+
+// RecordScalerFirstPollDuration measures the time between the creation of a scaler of the given
+// type and its first successful poll. It's recorded once per trigger of a ScaledObject, so the
+// scalers rebuilt with the cache don't count again, and returns false if it was already recorded.
+func RecordScalerFirstPollDuration(identifier string, scalerIndex int, scaler string, duration time.Duration) bool {
+	firstPollsLock.Lock()
+	defer firstPollsLock.Unlock()
+
+	if firstPolls[identifier][scalerIndex] {
+		return false
+	}
+	if firstPolls[identifier] == nil {
+		firstPolls[identifier] = make(map[int]bool)
+	}
+	firstPolls[identifier][scalerIndex] = true
+	scalerFirstPollDuration.WithLabelValues(scaler).Observe(duration.Seconds())
+	return true
+}
+
+// ForgetScalerFirstPolls forgets the first polls recorded for the ScaledObject, so they are
+// recorded again if it's recreated
+func ForgetScalerFirstPolls(identifier string) {
+	firstPollsLock.Lock()
+	defer firstPollsLock.Unlock()
+	delete(firstPolls, identifier)
+}
+
 func getLabels(namespace string, scaledObject string, scaler string, scalerIndex int, metric string) prometheus.Labels {
 	return prometheus.Labels{"namespace": namespace, "scaledObject": scaledObject, "scaler": scaler, "scalerIndex": strconv.Itoa(scalerIndex), "metric": metric}
 }
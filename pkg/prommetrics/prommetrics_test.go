@@ -0,0 +1,23 @@
+package prommetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordScalerFirstPollDuration(t *testing.T) {
+	identifier := "scaledobject.ns.first-poll"
+	defer ForgetScalerFirstPolls(identifier)
+
+	assert.True(t, RecordScalerFirstPollDuration(identifier, 0, "stan", time.Second))
+	// a scaler rebuilt with the cache doesn't record it again
+	assert.False(t, RecordScalerFirstPollDuration(identifier, 0, "stan", time.Second))
+	// the other triggers are tracked on their own
+	assert.True(t, RecordScalerFirstPollDuration(identifier, 1, "stan", time.Second))
+
+	// a recreated ScaledObject records it again
+	ForgetScalerFirstPolls(identifier)
+	assert.True(t, RecordScalerFirstPollDuration(identifier, 0, "stan", time.Second))
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2022 The KEDA Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scalers
+
+import "sync"
+
+// HealthTracker counts the recent failures and successes of a scaler. It is shared by
+// all the instances built for a trigger, so the history survives scaler refreshes.
+type HealthTracker struct {
+	lock                 sync.Mutex
+	failures             int
+	consecutiveSuccesses int
+	lastTrustedValue     float64
+}
+
+// NewHealthTracker creates a new HealthTracker without any recorded failure
+func NewHealthTracker() *HealthTracker {
+	return &HealthTracker{}
+}
+
+// RecordFailure records a failed attempt to get the metrics
+func (h *HealthTracker) RecordFailure() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.failures++
+	h.consecutiveSuccesses = 0
+}
+
+// RecordSuccess records a successful attempt to get the metrics. After a failure the
+// scaler is trusted again once minSuccessesBeforeTrust consecutive successes are recorded.
+func (h *HealthTracker) RecordSuccess(minSuccessesBeforeTrust int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.consecutiveSuccesses++
+	if h.consecutiveSuccesses >= minSuccessesBeforeTrust {
+		h.failures = 0
+	}
+}
+
+// IsTrusted returns whether the metrics can be trusted, that is no failure was recorded
+// since the scaler was last trusted
+func (h *HealthTracker) IsTrusted() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.failures == 0
+}
+
+// HoldValue returns the metric value to report. While the scaler is trusted the value is
+// returned as is and remembered. Otherwise the last trusted value is returned if it is
+// higher, so that the HPA doesn't scale in on metrics that can't be trusted yet.
+func (h *HealthTracker) HoldValue(value float64) float64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.failures == 0 {
+		h.lastTrustedValue = value
+		return value
+	}
+	if h.lastTrustedValue > value {
+		return h.lastTrustedValue
+	}
+	return value
+}
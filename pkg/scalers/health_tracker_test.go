@@ -0,0 +1,45 @@
+package scalers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthTracker(t *testing.T) {
+	tracker := NewHealthTracker()
+	assert.True(t, tracker.IsTrusted(), "no failure recorded")
+
+	tracker.RecordSuccess(2)
+	assert.True(t, tracker.IsTrusted())
+
+	tracker.RecordFailure()
+	tracker.RecordFailure()
+	assert.False(t, tracker.IsTrusted(), "failures recorded")
+	assert.False(t, tracker.IsTrusted(), "reading the trust doesn't change it")
+
+	tracker.RecordSuccess(2)
+	assert.False(t, tracker.IsTrusted(), "not enough successes")
+
+	tracker.RecordFailure()
+	tracker.RecordSuccess(2)
+	assert.False(t, tracker.IsTrusted(), "successes reset by a failure")
+
+	tracker.RecordSuccess(2)
+	assert.True(t, tracker.IsTrusted(), "enough consecutive successes")
+
+	tracker.RecordSuccess(2)
+	assert.True(t, tracker.IsTrusted(), "trust is kept")
+}
+
+func TestHealthTrackerHoldValue(t *testing.T) {
+	tracker := NewHealthTracker()
+	assert.Equal(t, float64(50), tracker.HoldValue(50))
+
+	tracker.RecordFailure()
+	assert.Equal(t, float64(50), tracker.HoldValue(5), "lower value held while untrusted")
+	assert.Equal(t, float64(80), tracker.HoldValue(80), "higher value reported while untrusted")
+
+	tracker.RecordSuccess(1)
+	assert.Equal(t, float64(5), tracker.HoldValue(5), "trusted again")
+}
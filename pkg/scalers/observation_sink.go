@@ -0,0 +1,171 @@
+/*
+Copyright 2022 The KEDA Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scalers
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ObservationSinkEnvVar is the file, or the unix socket prefixed with unix://, the scalers
+// write their observations to as JSON lines
+const ObservationSinkEnvVar = "KEDA_OBSERVATION_SINK"
+
+// observationSinkSize bounds the observations waiting to be written
+const observationSinkSize = 1000
+
+const (
+	// observationSinkWriteTimeout bounds a write, so a stuck reader doesn't stall the sink
+	observationSinkWriteTimeout = 5 * time.Second
+	observationSinkMinBackoff   = 500 * time.Millisecond
+	observationSinkMaxBackoff   = 30 * time.Second
+)
+
+var (
+	observationSink     *ObservationSink
+	observationSinkOnce sync.Once
+)
+
+// ObservationSink writes the raw observations of the scalers as JSON lines, so they can be
+// consumed by a sidecar. Writes happen in the background and observations are dropped when
+// the writer can't keep up, so recording never blocks the scalers. The writer is opened on
+// the first observation, and reopened with a backoff after it fails.
+type ObservationSink struct {
+	open         func() (io.WriteCloser, error)
+	observations chan []byte
+	done         chan struct{}
+	closeOnce    sync.Once
+	logger       logr.Logger
+}
+
+// NewObservationSink creates a sink writing to writer, keeping at most size pending observations
+func NewObservationSink(writer io.Writer, size int) *ObservationSink {
+	return newObservationSink(func() (io.WriteCloser, error) {
+		return nopWriteCloser{writer}, nil
+	}, size)
+}
+
+func newObservationSink(open func() (io.WriteCloser, error), size int) *ObservationSink {
+	sink := &ObservationSink{
+		open:         open,
+		observations: make(chan []byte, size),
+		done:         make(chan struct{}),
+		logger:       logf.Log.WithName("observation_sink"),
+	}
+	go sink.run()
+	return sink
+}
+
+// GetObservationSink returns the sink configured through KEDA_OBSERVATION_SINK, or nil if
+// there is none
+func GetObservationSink() *ObservationSink {
+	observationSinkOnce.Do(func() {
+		target := os.Getenv(ObservationSinkEnvVar)
+		if target == "" {
+			return
+		}
+
+		observationSink = newObservationSink(func() (io.WriteCloser, error) {
+			if strings.HasPrefix(target, "unix://") {
+				return net.DialTimeout("unix", strings.TrimPrefix(target, "unix://"), observationSinkWriteTimeout)
+			}
+			return os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		}, observationSinkSize)
+	})
+	return observationSink
+}
+
+// Record queues the observation to be written as a JSON line. It returns false if the
+// observation was dropped.
+func (s *ObservationSink) Record(observation interface{}) bool {
+	line, err := json.Marshal(observation)
+	if err != nil {
+		return false
+	}
+
+	select {
+	case s.observations <- append(line, '\n'):
+		return true
+	default:
+		return false
+	}
+}
+
+// Close writes the pending observations and stops the sink
+func (s *ObservationSink) Close() {
+	s.closeOnce.Do(func() {
+		close(s.observations)
+	})
+	<-s.done
+}
+
+func (s *ObservationSink) run() {
+	defer close(s.done)
+
+	var writer io.WriteCloser
+	var retryAt time.Time
+	backoff := observationSinkMinBackoff
+	defer func() {
+		if writer != nil {
+			writer.Close()
+		}
+	}()
+
+	for line := range s.observations {
+		if writer == nil {
+			// a failing sink only loses observations
+			if time.Now().Before(retryAt) {
+				continue
+			}
+			w, err := s.open()
+			if err != nil {
+				s.logger.Error(err, "Unable to open the observation sink", "retryIn", backoff)
+				retryAt = time.Now().Add(backoff)
+				if backoff *= 2; backoff > observationSinkMaxBackoff {
+					backoff = observationSinkMaxBackoff
+				}
+				continue
+			}
+			writer = w
+			backoff = observationSinkMinBackoff
+		}
+
+		if conn, ok := writer.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			// regular files don't support deadlines
+			_ = conn.SetWriteDeadline(time.Now().Add(observationSinkWriteTimeout))
+		}
+		if _, err := writer.Write(line); err != nil {
+			s.logger.Error(err, "Unable to write to the observation sink, reopening it")
+			writer.Close()
+			writer = nil
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,112 @@
+package scalers
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservationSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewObservationSink(&buf, 10)
+
+	assert.True(t, sink.Record(map[string]int{"lag": 5}))
+	assert.True(t, sink.Record(map[string]int{"lag": 7}))
+	sink.Close()
+
+	assert.Equal(t, "{\"lag\":5}\n{\"lag\":7}\n", buf.String())
+}
+
+func TestObservationSinkIsBounded(t *testing.T) {
+	// nothing reads the pipe, so the writer blocks
+	reader, writer := io.Pipe()
+	sink := NewObservationSink(writer, 2)
+
+	dropped := false
+	for i := 0; i < 10; i++ {
+		if !sink.Record(map[string]int{"lag": i}) {
+			dropped = true
+		}
+	}
+	assert.True(t, dropped)
+
+	reader.Close()
+	sink.Close()
+}
+
+func TestObservationSinkDialsLazily(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "observations.sock")
+	t.Setenv(ObservationSinkEnvVar, "unix://"+socket)
+	observationSink, observationSinkOnce = nil, sync.Once{}
+	t.Cleanup(func() {
+		observationSink, observationSinkOnce = nil, sync.Once{}
+	})
+
+	// nothing listens on the socket yet
+	sink := GetObservationSink()
+	assert.NotNil(t, sink)
+	assert.True(t, sink.Record(map[string]int{"lag": 1}))
+
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	// the sink retries once its backoff elapsed
+	deadline := time.After(5 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case line := <-lines:
+			// the first observation may have raced the listener
+			assert.Contains(t, []string{"{\"lag\":1}\n", "{\"lag\":2}\n"}, line)
+			sink.Close()
+			return
+		case <-ticker.C:
+			sink.Record(map[string]int{"lag": 2})
+		case <-deadline:
+			t.Fatal("the observation sink never connected to the socket")
+		}
+	}
+}
+
+func TestObservationSinkReopensAfterWriteError(t *testing.T) {
+	var buf bytes.Buffer
+	opened := 0
+	sink := newObservationSink(func() (io.WriteCloser, error) {
+		opened++
+		if opened == 1 {
+			return nopWriteCloser{failingWriter{}}, nil
+		}
+		return nopWriteCloser{&buf}, nil
+	}, 10)
+
+	assert.True(t, sink.Record(map[string]int{"lag": 1}))
+	assert.True(t, sink.Record(map[string]int{"lag": 2}))
+	sink.Close()
+
+	assert.Equal(t, 2, opened)
+	assert.Equal(t, "{\"lag\":2}\n", buf.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
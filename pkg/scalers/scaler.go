@@ -18,7 +18,11 @@ package scalers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,10 +31,12 @@ import (
 	v2 "k8s.io/api/autoscaling/v2"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/metrics/pkg/apis/external_metrics"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	kedav1alpha1 "github.com/kedacore/keda/v2/apis/keda/v1alpha1"
+	"github.com/kedacore/keda/v2/pkg/prommetrics"
 )
 
 func init() {
@@ -60,6 +66,33 @@ type PushScaler interface {
 	Run(ctx context.Context, active chan<- bool)
 }
 
+// HealthReportingScaler interface
+type HealthReportingScaler interface {
+	Scaler
+
+	// IsTrusted returns false while the scaler recovers from recent failures,
+	// the ScaledObject isn't deactivated meanwhile
+	IsTrusted() bool
+}
+
+// ConfigChecksumScaler interface
+type ConfigChecksumScaler interface {
+	Scaler
+
+	// ConfigChecksum returns a hash of the resolved configuration of the scaler, to detect
+	// drift between the scalers built by the operator and by the metrics server
+	ConfigChecksum() string
+}
+
+// AdaptivePollingScaler interface
+type AdaptivePollingScaler interface {
+	Scaler
+
+	// SuggestPollingInterval returns the delay before the next poll based on the last
+	// observation of the scaler, zero means no suggestion
+	SuggestPollingInterval() time.Duration
+}
+
 // ScalerConfig contains config fields common for all scalers
 type ScalerConfig struct {
 	// ScalableObjectName specifies name of the ScaledObject/ScaledJob that owns this scaler
@@ -98,6 +131,12 @@ type ScalerConfig struct {
 
 	// MetricType
 	MetricType v2.MetricTargetType
+
+	// HealthTracker records the failures of the scalers built for this trigger
+	HealthTracker *HealthTracker
+
+	// CardinalityGuard caps the series exported by the scalers built for this trigger
+	CardinalityGuard *prommetrics.CardinalityGuard
 }
 
 // GetFromAuthOrMeta helps getting a field from Auth or Meta sections
@@ -115,11 +154,115 @@ func GetFromAuthOrMeta(config *ScalerConfig, field string) (string, error) {
 	return result, err
 }
 
+// getGivenFromAuthOrMeta helps getting a field from Auth or Meta sections, reporting whether
+// it's given. A field given with an empty value is an error.
+func getGivenFromAuthOrMeta(config *ScalerConfig, field string) (string, bool, error) {
+	if val, err := GetFromAuthOrMeta(config, field); err == nil {
+		return val, true, nil
+	}
+	_, inAuth := config.AuthParams[field]
+	_, inMeta := config.TriggerMetadata[field]
+	if inAuth || inMeta {
+		return "", true, fmt.Errorf("error parsing %s: empty value", field)
+	}
+	return "", false, nil
+}
+
+// GetInt64FromAuthOrMeta helps getting a numeric field from Auth or Meta sections, falling back
+// to defaultValue when the field isn't given. A field given with an empty value is an error.
+func GetInt64FromAuthOrMeta(config *ScalerConfig, field string, defaultValue int64) (int64, error) {
+	val, given, err := getGivenFromAuthOrMeta(config, field)
+	if err != nil || !given {
+		return defaultValue, err
+	}
+	result, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return defaultValue, fmt.Errorf("error parsing %s: %w", field, err)
+	}
+	return result, nil
+}
+
+// GetBoolFromAuthOrMeta helps getting a boolean field from Auth or Meta sections, falling back
+// to defaultValue when the field isn't given
+func GetBoolFromAuthOrMeta(config *ScalerConfig, field string, defaultValue bool) (bool, error) {
+	val, err := GetFromAuthOrMeta(config, field)
+	if err != nil {
+		return defaultValue, nil
+	}
+	result, err := strconv.ParseBool(val)
+	if err != nil {
+		return defaultValue, fmt.Errorf("error parsing %s: %w", field, err)
+	}
+	return result, nil
+}
+
 // GenerateMetricNameWithIndex helps adding the index prefix to the metric name
 func GenerateMetricNameWithIndex(scalerIndex int, metricName string) string {
 	return fmt.Sprintf("s%d-%s", scalerIndex, metricName)
 }
 
+// GetConfigChecksum returns a stable hash of a resolved scaler configuration. Secrets must be
+// redacted from config beforehand.
+func GetConfigChecksum(config interface{}) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%#v", config)))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetImpliedReplicaCount returns the replica count the HPA would aim for with an AverageValue
+// target, which divides the metric value by the target
+func GetImpliedReplicaCount(value float64, target float64) int64 {
+	if target <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(value / target))
+}
+
+// GetThroughputTarget returns the AverageValue target of a backlog metric, such that the HPA runs
+// enough replicas to consume the backlog within drainSeconds when each replica handles
+// throughputPerReplica messages per second. It is rounded down so the backlog isn't drained late.
+func GetThroughputTarget(throughputPerReplica float64, drainSeconds float64) int64 {
+	return int64(math.Max(1, math.Floor(throughputPerReplica*drainSeconds)))
+}
+
+// GenerateMetricNameWithNamespace helps adding the namespace of the ScaledObject/ScaledJob to the metric name,
+// the metric name is returned as is when the namespace is empty
+func GenerateMetricNameWithNamespace(namespace string, metricName string) string {
+	if namespace == "" {
+		return metricName
+	}
+	return fmt.Sprintf("%s-%s", namespace, metricName)
+}
+
+// GenerateMetricNameWithSuffix helps appending a suffix, such as the environment, to the metric name,
+// the metric name is returned as is when the suffix is empty
+func GenerateMetricNameWithSuffix(metricName string, suffix string) string {
+	if suffix == "" {
+		return metricName
+	}
+	return fmt.Sprintf("%s-%s", metricName, suffix)
+}
+
+// maxMetricNameLength is the longest metric name generated, as metric names are DNS subdomains
+const maxMetricNameLength = validation.DNS1123SubdomainMaxLength
+
+// GenerateMetricNameWithLengthLimit adds a short hash of fullName, the value the name was
+// generated from before normalization, to a metric name that could collide with another one:
+// a name whose normalization changed fullName, such as a.b and a-b, or a name longer than
+// maxMetricNameLength, whose end is replaced by the hash. metricName may carry a prefix, such
+// as the scaler index, in front of the normalized fullName.
+func GenerateMetricNameWithLengthLimit(metricName string, fullName string) string {
+	normalized := strings.HasSuffix(metricName, fullName)
+	if normalized && len(metricName) <= maxMetricNameLength {
+		return metricName
+	}
+	sum := sha256.Sum256([]byte(fullName))
+	hash := hex.EncodeToString(sum[:])[:8]
+	if len(metricName)+len(hash)+1 <= maxMetricNameLength {
+		return fmt.Sprintf("%s-%s", metricName, hash)
+	}
+	return fmt.Sprintf("%s-%s", strings.TrimRight(metricName[:maxMetricNameLength-len(hash)-1], "-"), hash)
+}
+
 // RemoveIndexFromMetricName removes the index prefix from the metric name
 func RemoveIndexFromMetricName(scalerIndex int, metricName string) (string, error) {
 	metricNameSplit := strings.SplitN(metricName, "-", 2)
@@ -196,3 +339,10 @@ func GenerateMetricInMili(metricName string, value float64) external_metrics.Ext
 		Timestamp:  metav1.Now(),
 	}
 }
+
+// GenerateMetricInMiliWithPrecision returns a externalMetricValue with mili as metric scale,
+// rounding the value to the given number of decimal places (between 0 and 3)
+func GenerateMetricInMiliWithPrecision(metricName string, value float64, precision int) external_metrics.ExternalMetricValue {
+	scale := math.Pow10(precision)
+	return GenerateMetricInMili(metricName, math.Round(value*scale)/scale)
+}
@@ -2,6 +2,7 @@ package scalers
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -122,3 +123,221 @@ func TestRemoveIndexFromMetricName(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateMetricInMiliWithPrecision(t *testing.T) {
+	cases := []struct {
+		value     float64
+		precision int
+		expected  string
+	}{
+		{value: 1.23456, precision: 3, expected: "1235m"},
+		{value: 1.23456, precision: 2, expected: "1230m"},
+		{value: 1.25, precision: 1, expected: "1300m"},
+		{value: 1.5, precision: 0, expected: "2"},
+		{value: 10, precision: 0, expected: "10"},
+	}
+
+	for _, testCase := range cases {
+		metric := GenerateMetricInMiliWithPrecision("metricName", testCase.value, testCase.precision)
+		assert.Equal(t, testCase.expected, metric.Value.String())
+	}
+}
+
+func TestGetImpliedReplicaCount(t *testing.T) {
+	assert.Equal(t, int64(3), GetImpliedReplicaCount(25, 10))
+	assert.Equal(t, int64(2), GetImpliedReplicaCount(20, 10))
+	assert.Equal(t, int64(0), GetImpliedReplicaCount(0, 10))
+	assert.Equal(t, int64(0), GetImpliedReplicaCount(20, 0))
+}
+
+func TestGenerateMetricNameWithSuffix(t *testing.T) {
+	assert.Equal(t, "metricName-prod", GenerateMetricNameWithSuffix("metricName", "prod"))
+	assert.Equal(t, "metricName", GenerateMetricNameWithSuffix("metricName", ""))
+}
+
+func TestGenerateMetricNameWithLengthLimit(t *testing.T) {
+	assert.Equal(t, "s0-stan-orders", GenerateMetricNameWithLengthLimit("s0-stan-orders", "stan-orders"))
+
+	exact := "s0-" + strings.Repeat("a", maxMetricNameLength-3)
+	assert.Equal(t, exact, GenerateMetricNameWithLengthLimit(exact, exact))
+
+	long := "s0-stan-" + strings.Repeat("orders-", 50)
+	limited := GenerateMetricNameWithLengthLimit(long, long)
+	assert.Len(t, limited, maxMetricNameLength)
+	assert.True(t, strings.HasPrefix(limited, "s0-stan-orders-"))
+	assert.Equal(t, limited, GenerateMetricNameWithLengthLimit(long, long), "the name must be stable")
+	assert.NotEqual(t, limited, GenerateMetricNameWithLengthLimit(long, long+"other"))
+
+	// names normalized the same are told apart by the hash
+	dotted := GenerateMetricNameWithLengthLimit("s0-stan-a-b", "stan-a.b")
+	slashed := GenerateMetricNameWithLengthLimit("s0-stan-a-b", "stan-a/b")
+	assert.True(t, strings.HasPrefix(dotted, "s0-stan-a-b-"))
+	assert.NotEqual(t, dotted, slashed)
+	assert.Equal(t, "s0-stan-a-b", GenerateMetricNameWithLengthLimit("s0-stan-a-b", "stan-a-b"))
+	assert.Len(t, GenerateMetricNameWithLengthLimit(exact, strings.Replace(exact, "a", ".", 1)), maxMetricNameLength)
+}
+
+func TestGetThroughputTarget(t *testing.T) {
+	tests := []struct {
+		throughput   float64
+		drainSeconds float64
+		expected     int64
+	}{
+		{10, 60, 600},
+		{2.5, 30, 75},
+		{0.3, 10, 3},
+		{0.01, 10, 1},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, GetThroughputTarget(test.throughput, test.drainSeconds))
+	}
+}
+
+func TestGenerateMetricNameWithNamespace(t *testing.T) {
+	metricName := GenerateMetricNameWithIndex(0, GenerateMetricNameWithNamespace("my-namespace", "metricName"))
+	assert.Equal(t, "s0-my-namespace-metricName", metricName)
+
+	metricNameWithoutIndex, err := RemoveIndexFromMetricName(0, metricName)
+	assert.NoError(t, err)
+	assert.Equal(t, "my-namespace-metricName", metricNameWithoutIndex)
+
+	assert.Equal(t, "metricName", GenerateMetricNameWithNamespace("", "metricName"))
+}
+
+func TestGetConfigChecksum(t *testing.T) {
+	type config struct {
+		threshold int64
+		labels    map[string]bool
+	}
+
+	checksum := GetConfigChecksum(config{threshold: 10, labels: map[string]bool{"a": true, "b": true, "c": true}})
+	assert.Len(t, checksum, 64)
+	assert.Equal(t, checksum, GetConfigChecksum(config{threshold: 10, labels: map[string]bool{"c": true, "b": true, "a": true}}))
+	assert.NotEqual(t, checksum, GetConfigChecksum(config{threshold: 20, labels: map[string]bool{"a": true, "b": true, "c": true}}))
+}
+
+func TestGetInt64FromAuthOrMeta(t *testing.T) {
+	cases := []struct {
+		name      string
+		config    *ScalerConfig
+		wantValue int64
+		wantErr   string
+	}{
+		{
+			name:      "missing key",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{}},
+			wantValue: 10,
+		},
+		{
+			name:      "valid metadata",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"threshold": "25"}},
+			wantValue: 25,
+		},
+		{
+			name:      "valid auth",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"threshold": "25"}, AuthParams: map[string]string{"threshold": "-3"}},
+			wantValue: -3,
+		},
+		{
+			name:      "invalid",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"threshold": "ten"}},
+			wantValue: 10,
+			wantErr:   "error parsing threshold: strconv.ParseInt: parsing \"ten\": invalid syntax",
+		},
+		{
+			name:      "empty metadata",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"threshold": ""}},
+			wantValue: 10,
+			wantErr:   "error parsing threshold: empty value",
+		},
+		{
+			name:      "empty auth",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{}, AuthParams: map[string]string{"threshold": ""}},
+			wantValue: 10,
+			wantErr:   "error parsing threshold: empty value",
+		},
+		{
+			name:      "empty auth with metadata",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"threshold": "25"}, AuthParams: map[string]string{"threshold": ""}},
+			wantValue: 25,
+		},
+		{
+			name:      "out of range",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"threshold": "9223372036854775808"}},
+			wantValue: 10,
+			wantErr:   "error parsing threshold",
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.name, func(t *testing.T) {
+			value, err := GetInt64FromAuthOrMeta(c.config, "threshold", 10)
+			if c.wantErr != "" {
+				assert.ErrorContains(t, err, c.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, c.wantValue, value)
+		})
+	}
+}
+
+func TestGetBoolFromAuthOrMeta(t *testing.T) {
+	cases := []struct {
+		name         string
+		config       *ScalerConfig
+		defaultValue bool
+		wantValue    bool
+		wantErr      string
+	}{
+		{
+			name:      "true",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"flag": "true"}},
+			wantValue: true,
+		},
+		{
+			name:         "upper case false",
+			config:       &ScalerConfig{TriggerMetadata: map[string]string{"flag": "FALSE"}},
+			defaultValue: true,
+			wantValue:    false,
+		},
+		{
+			name:      "auth",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"flag": "false"}, AuthParams: map[string]string{"flag": "true"}},
+			wantValue: true,
+		},
+		{
+			name:         "empty",
+			config:       &ScalerConfig{TriggerMetadata: map[string]string{"flag": ""}},
+			defaultValue: true,
+			wantValue:    true,
+		},
+		{
+			name:         "missing key",
+			config:       &ScalerConfig{TriggerMetadata: map[string]string{}},
+			defaultValue: true,
+			wantValue:    true,
+		},
+		{
+			name:      "invalid",
+			config:    &ScalerConfig{TriggerMetadata: map[string]string{"flag": "notabool"}},
+			wantValue: false,
+			wantErr:   "error parsing flag: strconv.ParseBool: parsing \"notabool\": invalid syntax",
+		},
+	}
+
+	for _, testCase := range cases {
+		c := testCase
+		t.Run(c.name, func(t *testing.T) {
+			value, err := GetBoolFromAuthOrMeta(c.config, "flag", c.defaultValue)
+			if c.wantErr != "" {
+				assert.ErrorContains(t, err, c.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, c.wantValue, value)
+		})
+	}
+}
@@ -1,18 +1,40 @@
 package scalers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/tidwall/gjson"
 	v2 "k8s.io/api/autoscaling/v2"
 	"k8s.io/metrics/pkg/apis/external_metrics"
 
+	kedav1alpha1 "github.com/kedacore/keda/v2/apis/keda/v1alpha1"
+	"github.com/kedacore/keda/v2/pkg/prommetrics"
 	kedautil "github.com/kedacore/keda/v2/pkg/util"
+	"github.com/kedacore/keda/v2/version"
 )
 
 type monitorChannelInfo struct {
@@ -22,6 +44,24 @@ type monitorChannelInfo struct {
 	Subscriber   []monitorSubscriberInfo `json:"subscriptions"`
 }
 
+// monitorChannelList is the channelsz payload listing the channel names, one page at a time
+type monitorChannelList struct {
+	Offset int      `json:"offset"`
+	Count  int      `json:"count"`
+	Total  int      `json:"total"`
+	Names  []string `json:"names"`
+}
+
+type monitorServerInfo struct {
+	ClusterID     string `json:"cluster_id"`
+	ServerID      string `json:"server_id"`
+	Clients       int64  `json:"clients"`
+	Subscriptions int64  `json:"subscriptions"`
+	Channels      int64  `json:"channels"`
+	TotalMsgs     int64  `json:"total_msgs"`
+	TotalBytes    int64  `json:"total_bytes"`
+}
+
 type monitorSubscriberInfo struct {
 	ClientID     string `json:"client_id"`
 	QueueName    string `json:"queue_name"`
@@ -36,217 +76,3302 @@ type monitorSubscriberInfo struct {
 }
 
 type stanScaler struct {
+	metricType      v2.MetricTargetType
+	metadata        stanMetadata
+	httpClient      *http.Client
+	httpClientKey   stanHTTPClientKey
+	releaseClient   sync.Once
+	healthTracker   *HealthTracker
+	observationSink *ObservationSink
+	// subscriberGuard caps the subscribers exported when subscriberMetrics is set. It's shared
+	// by the scalers built for the trigger.
+	subscriberGuard *prommetrics.CardinalityGuard
+	resolver        stanSRVResolver
+	scaledObject    string
+	identifier      string
+	createdAt       time.Time
+	firstPoll       sync.Once
+	dryRun          bool
+	logger          logr.Logger
+
+	// stateLock guards the fields below, which are carried across polls
+	stateLock        sync.Mutex
+	lastSent         int64
+	lastSentAdvanced time.Time
+	lagSamples       []stanLagSample
+	pollingInterval  time.Duration
+	lastActive       time.Time
+	previousLag      int64
+	lastLagDecrease  time.Time
+	lastSequence     int64
+	lastSequenceTime time.Time
+	observationPolls int64
+	activatedAt      time.Time
+	wasActive        bool
+	// ackGaps holds the recent ack gaps of each subscriber, keyed by subject and client ID
+	ackGaps map[string][]stanLagSample
+	// srvEndpoint is the endpoint last resolved from srvRecord
+	srvEndpoint   *stanEndpoint
+	srvResolvedAt time.Time
+	// channelInfos holds the last channel info decoded from each cluster, to be served during
+	// an outage shorter than staleTolerance
+	channelInfos map[stanChannelInfoKey]stanCachedChannelInfo
+}
+
+// stanChannelInfoKey identifies a channel of a cluster
+type stanChannelInfoKey struct {
+	stanChannelsEndpoint string
+	subject              string
+}
+
+// stanCachedChannelInfo is a channel info along with the time it was fetched
+type stanCachedChannelInfo struct {
 	channelInfo *monitorChannelInfo
-	metricType  v2.MetricTargetType
-	metadata    stanMetadata
-	httpClient  *http.Client
-	logger      logr.Logger
+	fetchedAt   time.Time
+}
+
+// errStanTruncatedResponse is returned when a monitoring response was cut short. Partial data
+// would understate the lag, so the response is never decoded.
+var errStanTruncatedResponse = errors.New("truncated response from the nats streaming broker monitoring endpoint")
+
+// The failures to query a monitoring endpoint are categorized, so that errors.Is tells them apart
+var (
+	// ErrStanDNS is returned when the host of a monitoring endpoint can't be resolved
+	ErrStanDNS = errors.New("dns resolution of the nats streaming broker monitoring endpoint failed")
+	// ErrStanTLS is returned when the TLS handshake with a monitoring endpoint fails
+	ErrStanTLS = errors.New("tls handshake with the nats streaming broker monitoring endpoint failed")
+	// ErrStanStatus is returned when a monitoring endpoint answers with an unexpected status
+	ErrStanStatus = errors.New("unexpected status from the nats streaming broker monitoring endpoint")
+)
+
+// stanError wraps err into a category, keeping err in the chain for errors.As
+type stanError struct {
+	category error
+	err      error
+}
+
+func (e *stanError) Error() string {
+	return fmt.Sprintf("%s: %s", e.category, e.err)
+}
+
+func (e *stanError) Unwrap() error {
+	return e.err
+}
+
+func (e *stanError) Is(target error) bool {
+	return target == e.category
+}
+
+// categorizeStanRequestError wraps the error of a failed request into ErrStanDNS or ErrStanTLS
+// when it's due to the name resolution or to the TLS handshake
+func categorizeStanRequestError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &stanError{category: ErrStanDNS, err: err}
+	}
+
+	var recordHeaderErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+	// the alerts sent by the server, such as a rejected client certificate, aren't exported
+	if errors.As(err, &recordHeaderErr) || errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &certificateInvalidErr) || strings.Contains(err.Error(), "tls: ") {
+		return &stanError{category: ErrStanTLS, err: err}
+	}
+	return err
+}
+
+// newStanStatusError reports an unexpected status from a monitoring endpoint as ErrStanStatus
+func newStanStatusError(endpointName string, statusCode int) error {
+	return &stanError{category: ErrStanStatus, err: fmt.Errorf("nats streaming broker %s endpoint returned status %d", endpointName, statusCode)}
+}
+
+// stanSRVResolver looks up SRV records, as net.Resolver does
+type stanSRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// stanObservation is written to the observation sink for each subject found on a poll
+type stanObservation struct {
+	Subject   string    `json:"subject"`
+	Lag       int64     `json:"lag"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type stanLagSample struct {
+	timestamp time.Time
+	lag       int64
 }
 
 type stanMetadata struct {
-	monitoringEndpoint     string
-	stanChannelsEndpoint   string
-	queueGroup             string
-	durableName            string
-	subject                string
-	lagThreshold           int64
-	activationLagThreshold int64
-	scalerIndex            int
+	scope        string
+	serverMetric string
+	endpoints    []stanEndpoint
+	useHTTPS     bool
+	// useHTTPSSet disables the https inference for the bare hosts on the https port
+	useHTTPSSet                bool
+	monitoringPath             string
+	unsafeSsl                  bool
+	bearerToken                string
+	userAgent                  string
+	unixSocket                 string
+	ca                         string
+	cert                       string
+	key                        string
+	srvService                 string
+	srvProto                   string
+	srvName                    string
+	queueGroup                 string
+	durableName                string
+	subject                    string
+	subjects                   []string
+	subjectPattern             string
+	aggregation                string
+	subjectLagThresholds       map[string]int64
+	lagMode                    string
+	metric                     string
+	matchMode                  string
+	lagThreshold               int64
+	throughputPerReplica       float64
+	activationLagThreshold     int64
+	activationPendingThreshold int64
+	lagWeight                  float64
+	ageWeight                  float64
+	contentTypes               []string
+	lastSequencePath           string
+	subscribersPath            string
+	allowMissingContentType    bool
+	forecastSeconds            int64
+	recentWeightWindow         int
+	minSamples                 int
+	metricPrecision            int
+	anomalyFactor              float64
+	activationAcceleration     float64
+	minPollingInterval         time.Duration
+	maxPollingInterval         time.Duration
+	warmPoolReplicas           int64
+	quietPeriod                time.Duration
+	staleTolerance             time.Duration
+	jitter                     time.Duration
+	subscriptionGrace          time.Duration
+	maxLagAge                  time.Duration
+	maxLag                     int64
+	inflightAware              bool
+	minSuccessesBeforeTrust    int
+	followRedirects            bool
+	timeout                    time.Duration
+	maxRetries                 int
+	responseHeaderTimeout      time.Duration
+	expectContinueTimeout      time.Duration
+	exportThreshold            bool
+	dedupeSubscribers          bool
+	scaleOnStalled             bool
+	subscriberMetrics          bool
+	subscriberMetricsLimit     int
+	observationSampleRate      int
+	excludeClientIDs           map[string]bool
+	clientID                   string
+	expectedClusterID          string
+	clusterIDMismatch          string
+	namespacedMetricName       bool
+	queueGroupMetricName       bool
+	metricNameSuffix           string
+	modeCanary                 string
+	metricMode                 string
+	namespace                  string
+	scalerIndex                int
+}
+
+var metricNameSuffixPattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// stanClusterIDPattern matches the cluster IDs accepted by the NATS Streaming server
+var stanClusterIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// stanSRVRecordPattern matches SRV names such as _stan-monitor._tcp.example.com
+var stanSRVRecordPattern = regexp.MustCompile(`^_([a-zA-Z0-9-]+)\._(tcp|udp)\.([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*)\.?$`)
+
+// stanChannelScopeOptions can't be used when scaling on the server totals
+var stanChannelScopeOptions = []string{"queueGroup", "durableName", "subject", "subjectPattern", "matchMode", "aggregation", "subjectLagThresholds", "lagWeight", "ageWeight", "forecastSeconds", "recentWeightWindow", "minSamples", "anomalyFactor", "warmPoolReplicas", "quietPeriodSeconds", "staleTolerance", "subscriptionGraceSeconds", "maxLagAgeSeconds", "maxLag", "inflightAware", "activationLagAcceleration", "excludeClientIds", "clientID", "dedupeSubscribers", "scaleOnStalled", "queueGroupMetricName", "lagMode", "metric", "activationPendingThreshold", "subscriberMetrics", "subscriberMetricsLimit", "observationSampleRate", "modeCanary", "metricMode"}
+
+// stanOptionConflict declares options which can't be combined with an option, or with one of
+// its values
+type stanOptionConflict struct {
+	option string
+	// value restricts the conflict to a value of option, empty matches any value
+	value       string
+	conflicting []string
+}
+
+var stanOptionConflicts = []stanOptionConflict{
+	// the target is derived from the throughput
+	{option: "throughputPerReplica", conflicting: []string{lagThresholdMetricName}},
+	// both derive the lag from the recent samples
+	{option: "recentWeightWindow", conflicting: []string{"forecastSeconds"}},
+	// the pending messages replace the lag computed by lagMode
+	{option: "metric", value: stanMetricPending, conflicting: []string{"lagMode"}},
+	// the message count of the channel doesn't depend on the subscribers
+	{option: "metric", value: stanMetricMsgCount, conflicting: []string{"lagMode", "inflightAware", "metricMode"}},
+	// the subjects are the channels matching the pattern
+	{option: "subjectPattern", conflicting: []string{"subject", "subjectLagThresholds"}},
+	// a single subscriber is selected
+	{option: "clientID", conflicting: []string{"excludeClientIds"}},
+	// the monitoring endpoint is resolved from the SRV record
+	{option: "srvRecord", conflicting: []string{"natsServerMonitoringEndpoint"}},
+	// the ack gap trend is reported as is, so the options shaping the lag don't apply
+	{option: "lagMode", value: stanLagModeAckGapTrend, conflicting: []string{"aggregation", "subjectLagThresholds", "lagWeight", "ageWeight", "forecastSeconds", "recentWeightWindow", "minSamples", "anomalyFactor", "activationLagAcceleration", "warmPoolReplicas", "quietPeriodSeconds", "subscriptionGraceSeconds", "maxLagAgeSeconds", "modeCanary", "scaleOnStalled", "inflightAware"}},
+	// the publish rate is reported as is, so the options shaping the lag don't apply
+	{option: "metricMode", value: stanMetricModeRate, conflicting: []string{"lagMode", "metric", "aggregation", "subjectLagThresholds", "lagWeight", "ageWeight", "forecastSeconds", "recentWeightWindow", "minSamples", "anomalyFactor", "warmPoolReplicas", "maxLagAgeSeconds", "maxLag", "inflightAware", "modeCanary"}},
+}
+
+// stanEndpoint holds the monitoring URLs of one NATS Streaming cluster
+type stanEndpoint struct {
+	stanChannelsEndpoint string
+	serverzEndpoint      string
 }
 
 const (
-	stanMetricType             = "External"
-	defaultStanLagThreshold    = 10
-	natsStreamingHTTPProtocol  = "http"
-	natsStreamingHTTPSProtocol = "https"
+	stanMetricType                = "External"
+	stanScalerType                = "stan"
+	defaultStanLagThreshold       = 10
+	defaultStanContentType        = "application/json"
+	defaultStanMonitoringPath     = "/streaming/channelsz"
+	defaultStanDrainSeconds       = 60
+	defaultStanSubscriberMetrics  = 100
+	stanMaxLagSamples             = 10
+	stanSRVRefreshInterval        = 30 * time.Second
+	stanTruncatedResponseRetries  = 1
+	defaultStanMaxRetries         = 2
+	stanRetryBackoff              = 100 * time.Millisecond
+	stanMaxIdleConns              = 50
+	stanMaxIdleConnsPerHost       = 25
+	stanIdleConnTimeout           = 90 * time.Second
+	stanRedactedCredential        = "xxxxx"
+	stanOutcomeSuccess            = "success"
+	stanOutcomeHTTPError          = "http_error"
+	stanOutcomeDecodeError        = "decode_error"
+	stanOutcomeConnectionError    = "connection_error"
+	stanMaxMetricPrecision        = 3
+	stanMinAnomalySamples         = 3
+	stanScopeChannel              = "channel"
+	stanLagModeSequence           = "sequence"
+	stanLagModeCount              = "count"
+	stanLagModeOverflow           = "overflow"
+	stanLagModeAckGapTrend        = "ackGapTrend"
+	stanMetricLag                 = "lag"
+	stanAggregationMax            = "max"
+	stanAggregationSum            = "sum"
+	stanMetricPending             = "pending"
+	stanMetricMsgCount            = "msgCount"
+	stanMatchModeExact            = "exact"
+	stanMatchModeQueueOnly        = "queueOnly"
+	stanMatchModeDurableOnly      = "durableOnly"
+	stanModeCanaryShadowRate      = "shadowRate"
+	stanMetricModeLag             = "lag"
+	stanMetricModeRate            = "rate"
+	stanModeCanaryShadowLag       = "shadowLag"
+	stanScopeServer               = "server"
+	stanServerMetricMessages      = "messages"
+	stanServerMetricSubscriptions = "subscriptions"
+	stanClusterIDMismatchError    = "error"
+	stanClusterIDMismatchWarn     = "warn"
+	natsStreamingHTTPProtocol     = "http"
+	natsStreamingHTTPSProtocol    = "https"
+	natsStreamingUnixProtocol     = "unix"
+	stanHTTPSPort                 = "443"
+	stanUserAgentProduct          = "keda-stan-scaler"
+	stanBodySnippetLength         = 512
+	// stanUnixSocketHost is the host of the requests sent over a unix socket
+	stanUnixSocketHost = "localhost"
 )
 
+// stanEmergencyReplicas is the replica count implied by the metric when the lag is stuck, the
+// HPA caps it to its max replicas
+const stanEmergencyReplicas = 1000000
+
 // NewStanScaler creates a new stanScaler
 func NewStanScaler(config *ScalerConfig) (Scaler, error) {
-	metricType, err := GetMetricTargetType(config)
+	createdAt := time.Now()
+	metricType, stanMetadata, err := parseStanConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	healthTracker := config.HealthTracker
+	if healthTracker == nil {
+		healthTracker = NewHealthTracker()
+	}
+	subscriberGuard := config.CardinalityGuard
+	if subscriberGuard == nil {
+		subscriberGuard = prommetrics.NewCardinalityGuard(stanMetadata.subscriberMetricsLimit)
+	} else {
+		subscriberGuard.SetLimit(stanMetadata.subscriberMetricsLimit)
+	}
+
+	logger := InitializeLogger(config, "stan_scaler")
+	unsafeSsl := getStanUnsafeSsl(stanMetadata)
+	if unsafeSsl {
+		logger.Info("Warning: unsafeSsl is set, the certificates of the nats streaming monitoring endpoints aren't verified")
+	}
+
+	timeout := config.GlobalHTTPTimeout
+	if stanMetadata.timeout > 0 {
+		timeout = stanMetadata.timeout
+	}
+	httpClient, httpClientKey, err := getStanHTTPClient(stanMetadata, timeout, unsafeSsl)
 	if err != nil {
-		return nil, fmt.Errorf("error getting scaler metric type: %s", err)
+		return nil, err
+	}
+
+	scaler := &stanScaler{
+		metricType:      metricType,
+		metadata:        stanMetadata,
+		httpClient:      httpClient,
+		httpClientKey:   httpClientKey,
+		healthTracker:   healthTracker,
+		observationSink: GetObservationSink(),
+		subscriberGuard: subscriberGuard,
+		resolver:        net.DefaultResolver,
+		scaledObject:    config.ScalableObjectName,
+		identifier:      kedav1alpha1.GenerateIdentifier(config.ScalableObjectType, config.ScalableObjectNamespace, config.ScalableObjectName),
+		createdAt:       createdAt,
+		dryRun:          kedautil.IsDryRun(),
+		logger:          logger,
 	}
+	prommetrics.RecordScalerConstructionDuration(stanScalerType, time.Since(createdAt))
+	return scaler, nil
+}
 
-	stanMetadata, err := parseStanMetadata(config)
+// ValidateStanMetadata runs the validation of NewStanScaler without creating the scaler, so a
+// bad trigger can be rejected early, for instance by an admission webhook. It performs no
+// network I/O.
+func ValidateStanMetadata(config *ScalerConfig) error {
+	_, stanMetadata, err := parseStanConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing stan metadata: %s", err)
+		return err
 	}
+	_, err = getStanHTTPOptions(stanMetadata, getStanUnsafeSsl(stanMetadata))
+	return err
+}
 
-	return &stanScaler{
-		channelInfo: &monitorChannelInfo{},
-		metricType:  metricType,
-		metadata:    stanMetadata,
-		httpClient:  kedautil.CreateHTTPClient(config.GlobalHTTPTimeout, false),
-		logger:      InitializeLogger(config, "stan_scaler"),
-	}, nil
+// getStanUnsafeSsl reports whether the certificates are skipped. They are only skipped for the
+// endpoints reached through useHttps, or through the https port of an srv record.
+func getStanUnsafeSsl(meta stanMetadata) bool {
+	return meta.unsafeSsl && (meta.useHTTPS || (meta.srvName != "" && !meta.useHTTPSSet))
 }
 
-func parseStanMetadata(config *ScalerConfig) (stanMetadata, error) {
-	meta := stanMetadata{}
+// parseStanConfig parses the metric type and the metadata of the trigger, and checks that they
+// fit together
+func parseStanConfig(config *ScalerConfig) (v2.MetricTargetType, stanMetadata, error) {
+	metricType, err := GetMetricTargetType(config)
+	if err != nil {
+		return "", stanMetadata{}, fmt.Errorf("error getting scaler metric type: %s", err)
+	}
+	switch metricType {
+	case v2.ValueMetricType, v2.AverageValueMetricType:
+	default:
+		return "", stanMetadata{}, fmt.Errorf("error getting scaler metric type: '%s' metric type is unsupported, allowed values are '%s' or '%s'", metricType, v2.ValueMetricType, v2.AverageValueMetricType)
+	}
 
-	if config.TriggerMetadata["queueGroup"] == "" {
-		return meta, errors.New("no queue group given")
+	meta, err := parseStanMetadata(config)
+	if err != nil {
+		return "", meta, fmt.Errorf("error parsing stan metadata: %s", err)
 	}
-	meta.queueGroup = config.TriggerMetadata["queueGroup"]
 
-	if config.TriggerMetadata["durableName"] == "" {
-		return meta, errors.New("no durable name group given")
+	// the floor can only be expressed through the metric when the HPA divides it by the replica target
+	if meta.warmPoolReplicas > 0 && metricType != v2.AverageValueMetricType {
+		return "", meta, fmt.Errorf("warmPoolReplicas requires the '%s' metric type", v2.AverageValueMetricType)
+	}
+	if meta.throughputPerReplica > 0 && metricType != v2.AverageValueMetricType {
+		return "", meta, fmt.Errorf("throughputPerReplica requires the '%s' metric type", v2.AverageValueMetricType)
 	}
-	meta.durableName = config.TriggerMetadata["durableName"]
+	return metricType, meta, nil
+}
+
+// stanHTTPClientKey holds the settings shaping the client of a scaler. The bearer token and the
+// user agent are set on each request, so they don't need a client of their own. The CA and the
+// client certificate are only kept as a hash, so the key doesn't hold the private key.
+type stanHTTPClientKey struct {
+	timeout               time.Duration
+	unsafeSsl             bool
+	tlsMaterial           [sha256.Size]byte
+	unixSocket            string
+	responseHeaderTimeout time.Duration
+	expectContinueTimeout time.Duration
+	followRedirects       bool
+}
+
+// stanSharedHTTPClient is a client along with the number of scalers using it
+type stanSharedHTTPClient struct {
+	httpClient *http.Client
+	users      int
+}
+
+// stanHTTPClients are shared by the scalers with the same client settings, so the scalers of a
+// monitoring endpoint share its connection pool. A client is dropped once its last scaler is
+// closed.
+var stanHTTPClients = struct {
+	sync.Mutex
+	clients map[stanHTTPClientKey]*stanSharedHTTPClient
+}{clients: map[stanHTTPClientKey]*stanSharedHTTPClient{}}
 
-	if config.TriggerMetadata["subject"] == "" {
-		return meta, errors.New("no subject given")
+// getStanHTTPClient returns the shared client for the settings of meta, creating it on first use,
+// along with its key. Each call must be matched by a releaseStanHTTPClient.
+func getStanHTTPClient(meta stanMetadata, timeout time.Duration, unsafeSsl bool) (*http.Client, stanHTTPClientKey, error) {
+	key := stanHTTPClientKey{
+		timeout:               timeout,
+		unsafeSsl:             unsafeSsl,
+		tlsMaterial:           sha256.Sum256([]byte(meta.ca + "\x00" + meta.cert + "\x00" + meta.key)),
+		unixSocket:            meta.unixSocket,
+		responseHeaderTimeout: meta.responseHeaderTimeout,
+		expectContinueTimeout: meta.expectContinueTimeout,
+		followRedirects:       meta.followRedirects,
 	}
-	meta.subject = config.TriggerMetadata["subject"]
 
-	meta.lagThreshold = defaultStanLagThreshold
+	stanHTTPClients.Lock()
+	defer stanHTTPClients.Unlock()
 
-	if val, ok := config.TriggerMetadata[lagThresholdMetricName]; ok {
-		t, err := strconv.ParseInt(val, 10, 64)
-		if err != nil {
-			return meta, fmt.Errorf("error parsing %s: %s", lagThresholdMetricName, err)
+	if shared, ok := stanHTTPClients.clients[key]; ok {
+		shared.users++
+		return shared.httpClient, key, nil
+	}
+	httpOptions, err := getStanHTTPOptions(meta, unsafeSsl)
+	if err != nil {
+		return nil, key, err
+	}
+	httpClient := kedautil.CreateHTTPClient(timeout, unsafeSsl, httpOptions...)
+	if meta.followRedirects {
+		httpClient.CheckRedirect = checkStanRedirect
+	} else {
+		httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return fmt.Errorf("redirects are disabled, not following redirect to %s", req.URL)
 		}
-		meta.lagThreshold = t
 	}
+	stanHTTPClients.clients[key] = &stanSharedHTTPClient{httpClient: httpClient, users: 1}
+	return httpClient, key, nil
+}
+
+// releaseStanHTTPClient drops a use of the shared client of key, and reports whether it was the
+// last one, in which case the client is no longer shared
+func releaseStanHTTPClient(key stanHTTPClientKey) bool {
+	stanHTTPClients.Lock()
+	defer stanHTTPClients.Unlock()
+
+	shared, ok := stanHTTPClients.clients[key]
+	if !ok {
+		return false
+	}
+	shared.users--
+	if shared.users > 0 {
+		return false
+	}
+	delete(stanHTTPClients.clients, key)
+	return true
+}
 
-	meta.activationLagThreshold = 0
-	if val, ok := config.TriggerMetadata["activationLagThreshold"]; ok {
-		activationTargetQueryValue, err := strconv.ParseInt(val, 10, 64)
+// getStanHTTPOptions returns the options of the client querying the monitoring endpoints
+func getStanHTTPOptions(meta stanMetadata, unsafeSsl bool) ([]kedautil.HTTPClientOption, error) {
+	// the client is shared by the scalers polling the same few monitoring endpoints at once, so
+	// it keeps more idle connections to each of them than the client of a single scaler
+	httpOptions := []kedautil.HTTPClientOption{kedautil.WithIdleConnLimits(stanMaxIdleConns, stanMaxIdleConnsPerHost, stanIdleConnTimeout)}
+	if meta.unixSocket != "" {
+		httpOptions = append(httpOptions, kedautil.WithUnixSocket(meta.unixSocket))
+	}
+	if meta.responseHeaderTimeout > 0 {
+		httpOptions = append(httpOptions, kedautil.WithResponseHeaderTimeout(meta.responseHeaderTimeout))
+	}
+	if meta.expectContinueTimeout > 0 {
+		httpOptions = append(httpOptions, kedautil.WithExpectContinueTimeout(meta.expectContinueTimeout))
+	}
+	// the default TLS configuration is kept unless a CA or a client certificate is supplied
+	if meta.ca != "" || meta.cert != "" {
+		tlsConfig, err := kedautil.NewTLSConfig(meta.cert, meta.key, meta.ca)
 		if err != nil {
-			return meta, fmt.Errorf("activationLagThreshold parsing error %s", err.Error())
+			return nil, fmt.Errorf("error creating the tls config: %s", err)
 		}
-		meta.activationLagThreshold = activationTargetQueryValue
+		tlsConfig.InsecureSkipVerify = unsafeSsl
+		httpOptions = append(httpOptions, kedautil.WithTLSConfig(tlsConfig))
 	}
+	return httpOptions, nil
+}
 
-	meta.scalerIndex = config.ScalerIndex
-
+func parseStanMetadata(config *ScalerConfig) (stanMetadata, error) {
+	meta := stanMetadata{}
 	var err error
-	useHTTPS := false
-	if val, ok := config.TriggerMetadata["useHttps"]; ok {
-		useHTTPS, err = strconv.ParseBool(val)
-		if err != nil {
-			return meta, fmt.Errorf("useHTTPS parsing error %s", err.Error())
+
+	if err := checkStanOptionConflicts(config); err != nil {
+		return meta, err
+	}
+
+	meta.scope = stanScopeChannel
+	if val, ok := config.TriggerMetadata["scope"]; ok {
+		switch val {
+		case stanScopeChannel, stanScopeServer:
+			meta.scope = val
+		default:
+			return meta, fmt.Errorf("scope must be either '%s' or '%s', got '%s'", stanScopeChannel, stanScopeServer, val)
+		}
+	}
+
+	if meta.scope == stanScopeServer {
+		if err := parseStanServerScope(config, &meta); err != nil {
+			return meta, err
+		}
+	}
+
+	// the subscription is only needed when scaling on a single channel
+	if meta.scope == stanScopeChannel {
+		// non-durable queue subscribers are listed with the queue group alone, and plain
+		// durable subscribers without any
+		meta.matchMode = stanMatchModeExact
+		if val, ok := config.TriggerMetadata["matchMode"]; ok {
+			switch val {
+			case stanMatchModeExact, stanMatchModeQueueOnly, stanMatchModeDurableOnly:
+				meta.matchMode = val
+			default:
+				return meta, fmt.Errorf("matchMode must be one of '%s', '%s' or '%s', got '%s'", stanMatchModeExact, stanMatchModeQueueOnly, stanMatchModeDurableOnly, val)
+			}
+		}
+
+		// the names are matched exactly, so the whitespace left by a copy and paste is trimmed.
+		// durableOnly doesn't match the queue group, nor queueOnly the durable name.
+		meta.queueGroup, _ = GetFromAuthOrMeta(config, "queueGroup")
+		if meta.queueGroup = strings.TrimSpace(meta.queueGroup); meta.queueGroup == "" && meta.matchMode != stanMatchModeDurableOnly {
+			return meta, errors.New("no queue group given")
+		}
+
+		meta.durableName, _ = GetFromAuthOrMeta(config, "durableName")
+		if meta.durableName = strings.TrimSpace(meta.durableName); meta.durableName == "" && meta.matchMode != stanMatchModeQueueOnly {
+			return meta, errors.New("no durable name group given")
+		}
+
+		// the subscription is matched on the queue name durableName:queueGroup, so a colon in
+		// either of them means the combined name was given in a single field
+		if strings.Contains(meta.durableName, ":") {
+			return meta, fmt.Errorf("durableName %q must not contain ':', the durable name and queue group are given separately", meta.durableName)
+		}
+		if strings.Contains(meta.queueGroup, ":") {
+			return meta, fmt.Errorf("queueGroup %q must not contain ':', the durable name and queue group are given separately", meta.queueGroup)
+		}
+
+		meta.aggregation = stanAggregationMax
+		// the channels matching subjectPattern are listed on each poll, and their lags summed
+		if val, ok := config.TriggerMetadata["subjectPattern"]; ok {
+			val = strings.TrimSpace(val)
+			if _, err := path.Match(val, ""); err != nil || val == "" {
+				return meta, fmt.Errorf("subjectPattern %q must be a valid glob pattern", val)
+			}
+			meta.subjectPattern = val
+			meta.aggregation = stanAggregationSum
+		} else {
+			if meta.subject, err = GetFromAuthOrMeta(config, "subject"); err != nil {
+				return meta, errors.New("no subject given")
+			}
+			meta.subject = strings.TrimSpace(meta.subject)
+			// a comma separated list of subjects scales on the highest lag relative to its
+			// threshold, or on the sum of the relative lags
+			for _, subject := range strings.Split(meta.subject, ",") {
+				subject = strings.TrimSpace(subject)
+				if subject == "" {
+					return meta, errors.New("empty subject in subject")
+				}
+				meta.subjects = append(meta.subjects, subject)
+			}
+		}
+
+		if val, ok := config.TriggerMetadata["aggregation"]; ok {
+			switch val {
+			case stanAggregationMax, stanAggregationSum:
+				meta.aggregation = val
+			default:
+				return meta, fmt.Errorf("aggregation must be either '%s' or '%s', got '%s'", stanAggregationMax, stanAggregationSum, val)
+			}
 		}
 	}
-	natsServerEndpoint, err := GetFromAuthOrMeta(config, "natsServerMonitoringEndpoint")
+
+	meta.lagThreshold, err = GetInt64FromAuthOrMeta(config, lagThresholdMetricName, defaultStanLagThreshold)
 	if err != nil {
 		return meta, err
 	}
-	meta.stanChannelsEndpoint = getSTANChannelsEndpoint(useHTTPS, natsServerEndpoint)
-	meta.monitoringEndpoint = getMonitoringEndpoint(meta.stanChannelsEndpoint, meta.subject)
+	// the HPA rejects a zero target
+	if meta.lagThreshold <= 0 {
+		return meta, fmt.Errorf("%s must be greater than 0, got %d", lagThresholdMetricName, meta.lagThreshold)
+	}
 
-	return meta, nil
-}
+	if err := parseStanThroughputTarget(config, &meta); err != nil {
+		return meta, err
+	}
 
-func getSTANChannelsEndpoint(useHTTPS bool, natsServerEndpoint string) string {
-	protocol := natsStreamingHTTPProtocol
-	if useHTTPS {
-		protocol = natsStreamingHTTPSProtocol
+	if err := parseStanSubjectLagThresholds(config, &meta); err != nil {
+		return meta, err
 	}
-	return fmt.Sprintf("%s://%s/streaming/channelsz", protocol, natsServerEndpoint)
-}
 
-func getMonitoringEndpoint(stanChannelsEndpoint string, subject string) string {
-	return fmt.Sprintf("%s?channel=%s&subs=1", stanChannelsEndpoint, subject)
-}
+	meta.activationLagThreshold, err = GetInt64FromAuthOrMeta(config, "activationLagThreshold", 0)
+	if err != nil {
+		return meta, err
+	}
+	if meta.activationLagThreshold < 0 {
+		return meta, fmt.Errorf("activationLagThreshold must not be negative, got %d", meta.activationLagThreshold)
+	}
 
-func (s *stanScaler) getMaxMsgLag() int64 {
-	maxValue := int64(0)
-	combinedQueueName := s.metadata.durableName + ":" + s.metadata.queueGroup
+	// activationPendingThreshold replaces activationLagThreshold when scaling on the pending messages
+	meta.activationPendingThreshold, err = GetInt64FromAuthOrMeta(config, "activationPendingThreshold", 0)
+	if err != nil {
+		return meta, err
+	}
+	if meta.activationPendingThreshold < 0 {
+		return meta, fmt.Errorf("activationPendingThreshold must not be negative, got %d", meta.activationPendingThreshold)
+	}
 
-	for _, subs := range s.channelInfo.Subscriber {
-		if subs.LastSent > maxValue && subs.QueueName == combinedQueueName {
-			maxValue = subs.LastSent
-		}
+	if err := parseStanWeights(config, &meta); err != nil {
+		return meta, err
 	}
 
-	return s.channelInfo.LastSequence - maxValue
-}
+	if err := parseStanMetricOptions(config, &meta); err != nil {
+		return meta, err
+	}
 
-func (s *stanScaler) hasPendingMessage() bool {
-	subscriberFound := false
-	combinedQueueName := s.metadata.durableName + ":" + s.metadata.queueGroup
+	if err := parseStanPollingIntervals(config, &meta); err != nil {
+		return meta, err
+	}
 
-	for _, subs := range s.channelInfo.Subscriber {
-		if subs.QueueName == combinedQueueName {
-			subscriberFound = true
+	meta.warmPoolReplicas = 0
+	if val, ok := config.TriggerMetadata["warmPoolReplicas"]; ok {
+		warmPoolReplicas, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return meta, fmt.Errorf("warmPoolReplicas parsing error %s", err.Error())
+		}
+		if warmPoolReplicas < 0 {
+			return meta, errors.New("warmPoolReplicas must not be negative")
+		}
+		meta.warmPoolReplicas = warmPoolReplicas
+	}
 
-			if subs.PendingCount > 0 {
-				return true
-			}
+	meta.quietPeriod = 0
+	if val, ok := config.TriggerMetadata["quietPeriodSeconds"]; ok {
+		quietPeriodSeconds, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return meta, fmt.Errorf("quietPeriodSeconds parsing error %s", err.Error())
+		}
+		if quietPeriodSeconds < 0 {
+			return meta, errors.New("quietPeriodSeconds must not be negative")
+		}
+		meta.quietPeriod = time.Duration(quietPeriodSeconds) * time.Second
+	}
 
-			break
+	// staleTolerance is how long the last channel info is served while a cluster is unreachable
+	meta.staleTolerance = 0
+	if val, ok := config.TriggerMetadata["staleTolerance"]; ok {
+		staleTolerance, err := time.ParseDuration(val)
+		if err != nil {
+			return meta, fmt.Errorf("staleTolerance parsing error %s", err.Error())
+		}
+		if staleTolerance < 0 {
+			return meta, errors.New("staleTolerance must not be negative")
 		}
+		meta.staleTolerance = staleTolerance
 	}
 
-	if !subscriberFound {
-		s.logger.Info("The STAN subscription was not found.", "combinedQueueName", combinedQueueName)
+	// jitter spreads the polls of the scalers sharing a monitoring endpoint
+	meta.jitter = 0
+	if val, ok := config.TriggerMetadata["jitter"]; ok {
+		jitter, err := time.ParseDuration(val)
+		if err != nil {
+			return meta, fmt.Errorf("jitter parsing error %s", err.Error())
+		}
+		if jitter < 0 {
+			return meta, errors.New("jitter must not be negative")
+		}
+		meta.jitter = jitter
 	}
 
-	return false
-}
+	meta.subscriptionGrace = 0
+	if val, ok := config.TriggerMetadata["subscriptionGraceSeconds"]; ok {
+		subscriptionGraceSeconds, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return meta, fmt.Errorf("subscriptionGraceSeconds parsing error %s", err.Error())
+		}
+		if subscriptionGraceSeconds < 0 {
+			return meta, errors.New("subscriptionGraceSeconds must not be negative")
+		}
+		meta.subscriptionGrace = time.Duration(subscriptionGraceSeconds) * time.Second
+	}
 
-func (s *stanScaler) GetMetricSpecForScaling(context.Context) []v2.MetricSpec {
-	metricName := kedautil.NormalizeString(fmt.Sprintf("stan-%s", s.metadata.subject))
-	externalMetric := &v2.ExternalMetricSource{
-		Metric: v2.MetricIdentifier{
-			Name: GenerateMetricNameWithIndex(s.metadata.scalerIndex, metricName),
-		},
-		Target: GetMetricTarget(s.metricType, s.metadata.lagThreshold),
+	meta.maxLagAge = 0
+	if val, ok := config.TriggerMetadata["maxLagAgeSeconds"]; ok {
+		maxLagAgeSeconds, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return meta, fmt.Errorf("maxLagAgeSeconds parsing error %s", err.Error())
+		}
+		if maxLagAgeSeconds <= 0 {
+			return meta, errors.New("maxLagAgeSeconds must be greater than 0")
+		}
+		meta.maxLagAge = time.Duration(maxLagAgeSeconds) * time.Second
 	}
-	metricSpec := v2.MetricSpec{
-		External: externalMetric, Type: stanMetricType,
+
+	// maxLag caps the lag of a channel, so a corrupt last sequence doesn't scale to the max replicas
+	meta.maxLag = 0
+	maxLag, maxLagGiven, err := getGivenFromAuthOrMeta(config, "maxLag")
+	if err != nil {
+		return meta, err
+	}
+	if maxLagGiven {
+		if meta.maxLag, err = strconv.ParseInt(maxLag, 10, 64); err != nil {
+			return meta, fmt.Errorf("error parsing maxLag: %w", err)
+		}
+		if meta.maxLag <= 0 {
+			return meta, errors.New("maxLag must be greater than 0")
+		}
 	}
-	return []v2.MetricSpec{metricSpec}
-}
 
-// GetMetricsAndActivity returns value for a supported metric and an error if there is a problem getting the metric
-func (s *stanScaler) GetMetricsAndActivity(ctx context.Context, metricName string) ([]external_metrics.ExternalMetricValue, bool, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", s.metadata.monitoringEndpoint, nil)
+	// inflightAware reports the lag in units of the max inflight of the subscribers
+	meta.inflightAware, err = GetBoolFromAuthOrMeta(config, "inflightAware", false)
 	if err != nil {
-		return []external_metrics.ExternalMetricValue{}, false, err
+		return meta, err
+	}
+
+	meta.minSuccessesBeforeTrust = 0
+	if val, ok := config.TriggerMetadata["minSuccessesBeforeTrust"]; ok {
+		minSuccessesBeforeTrust, err := strconv.Atoi(val)
+		if err != nil {
+			return meta, fmt.Errorf("minSuccessesBeforeTrust parsing error %s", err.Error())
+		}
+		if minSuccessesBeforeTrust < 0 {
+			return meta, errors.New("minSuccessesBeforeTrust must not be negative")
+		}
+		meta.minSuccessesBeforeTrust = minSuccessesBeforeTrust
+	}
+
+	meta.contentTypes = []string{defaultStanContentType}
+	if val, ok := config.TriggerMetadata["contentTypes"]; ok && val != "" {
+		meta.contentTypes = nil
+		for _, contentType := range strings.Split(val, ",") {
+			contentType = strings.TrimSpace(contentType)
+			if _, _, err := mime.ParseMediaType(contentType); err != nil {
+				return meta, fmt.Errorf("contentTypes parsing error %s", err.Error())
+			}
+			meta.contentTypes = append(meta.contentTypes, contentType)
+		}
+	}
+
+	meta.scalerIndex = config.ScalerIndex
+	meta.namespace = config.ScalableObjectNamespace
+
+	meta.allowMissingContentType = false
+	if val, ok := config.TriggerMetadata["allowMissingContentType"]; ok {
+		meta.allowMissingContentType, err = strconv.ParseBool(val)
+		if err != nil {
+			return meta, fmt.Errorf("allowMissingContentType parsing error %s", err.Error())
+		}
+	}
+
+	if err := parseStanMetricName(config, &meta); err != nil {
+		return meta, err
 	}
-	resp, err := s.httpClient.Do(req)
 
+	useHTTPS, err := GetBoolFromAuthOrMeta(config, "useHttps", false)
 	if err != nil {
-		s.logger.Error(err, "Unable to access the nats streaming broker monitoring endpoint", "monitoringEndpoint", s.metadata.monitoringEndpoint)
-		return []external_metrics.ExternalMetricValue{}, false, err
+		return meta, err
+	}
+	meta.unsafeSsl = false
+	if val, ok := config.TriggerMetadata["unsafeSsl"]; ok {
+		meta.unsafeSsl, err = strconv.ParseBool(val)
+		if err != nil {
+			return meta, fmt.Errorf("unsafeSsl parsing error %s", err.Error())
+		}
+	}
+	if val, ok := config.TriggerMetadata["excludeClientIds"]; ok && val != "" {
+		meta.excludeClientIDs = map[string]bool{}
+		for _, clientID := range strings.Split(val, ",") {
+			clientID = strings.TrimSpace(clientID)
+			if clientID == "" {
+				return meta, errors.New("empty client ID in excludeClientIds")
+			}
+			meta.excludeClientIDs[clientID] = true
+		}
+	}
+	// clientID restricts the queue group to one of its subscribers
+	meta.clientID = ""
+	if val, ok := config.TriggerMetadata["clientID"]; ok {
+		if meta.clientID = strings.TrimSpace(val); meta.clientID == "" {
+			return meta, errors.New("clientID must not be empty")
+		}
 	}
 
-	if resp.StatusCode == 404 {
-		req, err := http.NewRequestWithContext(ctx, "GET", s.metadata.stanChannelsEndpoint, nil)
+	meta.dedupeSubscribers = false
+	if val, ok := config.TriggerMetadata["dedupeSubscribers"]; ok {
+		meta.dedupeSubscribers, err = strconv.ParseBool(val)
 		if err != nil {
-			return []external_metrics.ExternalMetricValue{}, false, err
+			return meta, fmt.Errorf("dedupeSubscribers parsing error %s", err.Error())
 		}
-		baseResp, err := s.httpClient.Do(req)
+	}
+	// scaleOnStalled activates the scaler when a subscriber of the queue group is stalled,
+	// whatever the lag compared to activationLagThreshold
+	meta.scaleOnStalled = false
+	if val, ok := config.TriggerMetadata["scaleOnStalled"]; ok {
+		meta.scaleOnStalled, err = strconv.ParseBool(val)
 		if err != nil {
-			return []external_metrics.ExternalMetricValue{}, false, err
+			return meta, fmt.Errorf("scaleOnStalled parsing error %s", err.Error())
 		}
-		defer baseResp.Body.Close()
-		if baseResp.StatusCode == 404 {
-			s.logger.Info("Streaming broker endpoint returned 404. Please ensure it has been created", "url", s.metadata.monitoringEndpoint, "channelName", s.metadata.subject)
-		} else {
-			s.logger.Info("Unable to connect to STAN. Please ensure you have configured the ScaledObject with the correct endpoint.", "baseResp.StatusCode", baseResp.StatusCode, "monitoringEndpoint", s.metadata.monitoringEndpoint)
+	}
+	meta.followRedirects = true
+	if val, ok := config.TriggerMetadata["followRedirects"]; ok {
+		meta.followRedirects, err = strconv.ParseBool(val)
+		if err != nil {
+			return meta, fmt.Errorf("followRedirects parsing error %s", err.Error())
+		}
+	}
+	meta.exportThreshold = false
+	if val, ok := config.TriggerMetadata["exportThreshold"]; ok {
+		meta.exportThreshold, err = strconv.ParseBool(val)
+		if err != nil {
+			return meta, fmt.Errorf("exportThreshold parsing error %s", err.Error())
 		}
+	}
 
-		return []external_metrics.ExternalMetricValue{}, false, err
+	// the rate scales bursty channels on their ingress rather than on their noisy lag
+	meta.metricMode = stanMetricModeLag
+	if val, ok := config.TriggerMetadata["metricMode"]; ok {
+		switch val {
+		case stanMetricModeLag, stanMetricModeRate:
+			meta.metricMode = val
+		default:
+			return meta, fmt.Errorf("metricMode must be either '%s' or '%s', got '%s'", stanMetricModeLag, stanMetricModeRate, val)
+		}
 	}
 
-	defer resp.Body.Close()
-	if err := json.NewDecoder(resp.Body).Decode(&s.channelInfo); err != nil {
-		s.logger.Error(err, "Unable to decode channel info as %v", err)
-		return []external_metrics.ExternalMetricValue{}, false, err
+	meta.modeCanary = ""
+	if val, ok := config.TriggerMetadata["modeCanary"]; ok {
+		switch val {
+		case stanModeCanaryShadowRate, stanModeCanaryShadowLag:
+			meta.modeCanary = val
+		default:
+			return meta, fmt.Errorf("modeCanary must be either '%s' or '%s', got '%s'", stanModeCanaryShadowRate, stanModeCanaryShadowLag, val)
+		}
+	}
+
+	if err := parseStanFieldPaths(config, &meta); err != nil {
+		return meta, err
 	}
-	totalLag := s.getMaxMsgLag()
-	s.logger.V(1).Info("Stan scaler: Providing metrics based on totalLag, threshold", "totalLag", totalLag, "lagThreshold", s.metadata.lagThreshold)
 
-	metric := GenerateMetricInMili(metricName, float64(totalLag))
+	if err := parseStanSubscriberMetrics(config, &meta); err != nil {
+		return meta, err
+	}
 
-	return []external_metrics.ExternalMetricValue{metric}, s.hasPendingMessage() || totalLag > s.metadata.activationLagThreshold, nil
-}
+	if err := parseStanClusterID(config, &meta); err != nil {
+		return meta, err
+	}
 
-// Nothing to close here.
-func (s *stanScaler) Close(context.Context) error {
+	if err := parseStanHTTPTimeouts(config, &meta); err != nil {
+		return meta, err
+	}
+
+	meta.maxRetries = defaultStanMaxRetries
+	if val, ok := config.TriggerMetadata["maxRetries"]; ok {
+		maxRetries, err := strconv.Atoi(val)
+		if err != nil {
+			return meta, fmt.Errorf("maxRetries parsing error %s", err.Error())
+		}
+		if maxRetries < 0 {
+			return meta, errors.New("maxRetries must not be negative")
+		}
+		meta.maxRetries = maxRetries
+	}
+
+	if err := parseStanTLS(config, &meta); err != nil {
+		return meta, err
+	}
+
+	meta.useHTTPS = useHTTPS
+	_, useHTTPSErr := GetFromAuthOrMeta(config, "useHttps")
+	meta.useHTTPSSet = useHTTPSErr == nil
+	// the monitoring API may be served under a prefix, the serverz endpoint is expected next
+	// to channelsz
+	meta.monitoringPath = defaultStanMonitoringPath
+	if val, ok := config.TriggerMetadata["monitoringPath"]; ok {
+		monitoringPath := strings.TrimSuffix(val, "/")
+		if !strings.HasPrefix(monitoringPath, "/") || strings.ContainsAny(monitoringPath, "?#") {
+			return meta, fmt.Errorf("monitoringPath %q must be an absolute path without query or fragment", val)
+		}
+		meta.monitoringPath = monitoringPath
+	}
+
+	// the monitoring endpoint may log and rate limit the requests by user agent
+	meta.userAgent = fmt.Sprintf("%s/%s", stanUserAgentProduct, version.Version)
+	if val, ok := config.TriggerMetadata["userAgent"]; ok && val != "" {
+		meta.userAgent = val
+	}
+
+	// the monitoring endpoint may sit behind a proxy requiring a bearer token
+	meta.bearerToken = ""
+	if bearerToken, err := GetFromAuthOrMeta(config, "bearerToken"); err == nil {
+		meta.bearerToken = bearerToken
+	}
+
+	// the endpoint is resolved on each poll when it's published as an SRV record
+	if val, ok := config.TriggerMetadata["srvRecord"]; ok {
+		match := stanSRVRecordPattern.FindStringSubmatch(val)
+		if match == nil {
+			return meta, fmt.Errorf("srvRecord %q must have the form _service._proto.name, with 'tcp' or 'udp' as proto", val)
+		}
+		meta.srvService, meta.srvProto, meta.srvName = match[1], match[2], match[3]
+		return meta, nil
+	}
+
+	natsServerEndpoints, err := GetFromAuthOrMeta(config, "natsServerMonitoringEndpoint")
+	if err != nil {
+		return meta, err
+	}
+	natsServerEndpoints = strings.TrimSpace(natsServerEndpoints)
+	// a comma separated list of endpoints scales on the combined lag of several clusters
+	useHTTPSSet := meta.useHTTPSSet
+	natsServerEndpointList := strings.Split(natsServerEndpoints, ",")
+	for _, natsServerEndpoint := range natsServerEndpointList {
+		natsServerEndpoint = strings.TrimSpace(natsServerEndpoint)
+		// an endpoint carrying its scheme must agree with useHttps, or implies it
+		switch getStanEndpointScheme(natsServerEndpoint) {
+		case natsStreamingUnixProtocol:
+			// the socket is dialed by the client, which all the endpoints share
+			if len(natsServerEndpointList) > 1 {
+				return meta, fmt.Errorf("endpoint %q in natsServerMonitoringEndpoint can't be combined with other endpoints", natsServerEndpoint)
+			}
+			if useHTTPS {
+				return meta, fmt.Errorf("endpoint %q in natsServerMonitoringEndpoint conflicts with useHttps 'true'", natsServerEndpoint)
+			}
+			endpoint, socketPath, err := parseStanUnixEndpoint(meta.monitoringPath, natsServerEndpoint)
+			if err != nil {
+				return meta, err
+			}
+			meta.unixSocket = socketPath
+			meta.endpoints = append(meta.endpoints, endpoint)
+			continue
+		case natsStreamingHTTPSProtocol:
+			if useHTTPSSet && !useHTTPS {
+				return meta, fmt.Errorf("endpoint %q in natsServerMonitoringEndpoint conflicts with useHttps 'false'", natsServerEndpoint)
+			}
+			meta.useHTTPS = true
+		case natsStreamingHTTPProtocol:
+			if useHTTPSSet && useHTTPS {
+				return meta, fmt.Errorf("endpoint %q in natsServerMonitoringEndpoint conflicts with useHttps 'true'", natsServerEndpoint)
+			}
+		case "":
+			// a bare host on the https port implies https, unless useHttps says otherwise
+			if inferStanHTTPS(&meta, natsServerEndpoint) {
+				endpoint, err := parseStanEndpoint(true, meta.monitoringPath, natsServerEndpoint)
+				if err != nil {
+					return meta, err
+				}
+				meta.useHTTPS = true
+				meta.endpoints = append(meta.endpoints, endpoint)
+				continue
+			}
+		}
+		endpoint, err := parseStanEndpoint(useHTTPS, meta.monitoringPath, natsServerEndpoint)
+		if err != nil {
+			return meta, err
+		}
+		meta.endpoints = append(meta.endpoints, endpoint)
+	}
+
+	return meta, nil
+}
+
+// getStanEndpointScheme returns the scheme the endpoint carries, or an empty string for a bare host
+func getStanEndpointScheme(natsServerEndpoint string) string {
+	scheme, _, found := strings.Cut(natsServerEndpoint, "://")
+	if !found {
+		return ""
+	}
+	return strings.ToLower(scheme)
+}
+
+// inferStanHTTPS reports whether a bare host endpoint is reached over https because it's on the
+// https port. useHttps disables the inference.
+func inferStanHTTPS(meta *stanMetadata, natsServerEndpoint string) bool {
+	return !meta.useHTTPSSet && getStanEndpointPort(natsServerEndpoint) == stanHTTPSPort
+}
+
+// getStanEndpointPort returns the port of a bare host endpoint, or an empty string without one
+func getStanEndpointPort(natsServerEndpoint string) string {
+	_, port, err := net.SplitHostPort(strings.TrimSuffix(natsServerEndpoint, "/"))
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// parseStanEndpoint validates the monitoring endpoint of one cluster. The endpoint may
+// carry its own http:// or https:// scheme, which takes precedence over useHttps.
+func parseStanEndpoint(useHTTPS bool, monitoringPath string, natsServerEndpoint string) (stanEndpoint, error) {
+	if natsServerEndpoint == "" {
+		return stanEndpoint{}, errors.New("empty endpoint in natsServerMonitoringEndpoint")
+	}
+
+	baseURL, err := url.Parse(getSTANBaseURL(useHTTPS, natsServerEndpoint))
+	if err != nil {
+		return stanEndpoint{}, fmt.Errorf("invalid endpoint %q in natsServerMonitoringEndpoint: %s", natsServerEndpoint, err)
+	}
+	if baseURL.Scheme != natsStreamingHTTPProtocol && baseURL.Scheme != natsStreamingHTTPSProtocol {
+		return stanEndpoint{}, fmt.Errorf("invalid endpoint %q in natsServerMonitoringEndpoint: unsupported scheme %q", natsServerEndpoint, baseURL.Scheme)
+	}
+	if baseURL.Hostname() == "" {
+		return stanEndpoint{}, fmt.Errorf("invalid endpoint %q in natsServerMonitoringEndpoint: missing host", natsServerEndpoint)
+	}
+	// the path is appended to the endpoint, so it can only hold the host
+	if baseURL.Path != "" {
+		return stanEndpoint{}, fmt.Errorf("invalid endpoint %q in natsServerMonitoringEndpoint: unexpected path %q, use monitoringPath instead", natsServerEndpoint, baseURL.Path)
+	}
+	if baseURL.RawQuery != "" || baseURL.Fragment != "" {
+		return stanEndpoint{}, fmt.Errorf("invalid endpoint %q in natsServerMonitoringEndpoint: unexpected query or fragment", natsServerEndpoint)
+	}
+
+	endpoint := stanEndpoint{
+		stanChannelsEndpoint: getSTANChannelsEndpoint(useHTTPS, monitoringPath, natsServerEndpoint),
+		serverzEndpoint:      getSTANServerzEndpoint(useHTTPS, monitoringPath, natsServerEndpoint),
+	}
+	if _, err := url.ParseRequestURI(endpoint.stanChannelsEndpoint); err != nil {
+		return stanEndpoint{}, fmt.Errorf("invalid endpoint %q in natsServerMonitoringEndpoint: %s", natsServerEndpoint, err)
+	}
+	return endpoint, nil
+}
+
+// parseStanUnixEndpoint validates an endpoint of the form unix:///path/to/socket and returns
+// the socket path. The requests are sent over plain HTTP to stanUnixSocketHost, the client
+// dialing the socket instead.
+func parseStanUnixEndpoint(monitoringPath string, natsServerEndpoint string) (stanEndpoint, string, error) {
+	socketPath := natsServerEndpoint[len(natsStreamingUnixProtocol+"://"):]
+	if !strings.HasPrefix(socketPath, "/") {
+		return stanEndpoint{}, "", fmt.Errorf("invalid endpoint %q in natsServerMonitoringEndpoint: the socket path must be absolute", natsServerEndpoint)
+	}
+	endpoint, err := parseStanEndpoint(false, monitoringPath, stanUnixSocketHost)
+	return endpoint, socketPath, err
+}
+
+// parseStanMetricName reads the options shaping the metric name
+func parseStanMetricName(config *ScalerConfig, meta *stanMetadata) error {
+	var err error
+	meta.namespacedMetricName = false
+	if val, ok := config.TriggerMetadata["namespacedMetricName"]; ok {
+		meta.namespacedMetricName, err = strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("namespacedMetricName parsing error %s", err.Error())
+		}
+	}
+	if meta.namespacedMetricName && meta.namespace == "" {
+		return errors.New("namespacedMetricName requires the namespace of the ScaledObject")
+	}
+
+	// queueGroupMetricName tells apart the triggers of several queue groups on the same subject,
+	// it's off by default as it changes the name of the metric
+	meta.queueGroupMetricName, err = GetBoolFromAuthOrMeta(config, "queueGroupMetricName", false)
+	if err != nil {
+		return err
+	}
+
+	meta.metricNameSuffix = ""
+	if val, ok := config.TriggerMetadata["metricNameSuffix"]; ok && val != "" {
+		if !metricNameSuffixPattern.MatchString(val) {
+			return fmt.Errorf("metricNameSuffix %q must only contain alphanumeric characters, '.', '_' or '-'", val)
+		}
+		meta.metricNameSuffix = val
+	}
+
+	return nil
+}
+
+// checkStanOptionConflicts returns an error listing the options which can't be combined. The
+// options are resolved from the auth params or the trigger metadata, as they are parsed.
+func checkStanOptionConflicts(config *ScalerConfig) error {
+	var conflicts []string
+	for _, conflict := range stanOptionConflicts {
+		val, err := GetFromAuthOrMeta(config, conflict.option)
+		if err != nil || (conflict.value != "" && val != conflict.value) {
+			continue
+		}
+		option := conflict.option
+		if conflict.value != "" {
+			option = fmt.Sprintf("%s=%s", conflict.option, conflict.value)
+		}
+		for _, key := range conflict.conflicting {
+			if _, err := GetFromAuthOrMeta(config, key); err == nil {
+				conflicts = append(conflicts, fmt.Sprintf("%s and %s", option, key))
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting options can't be used together: %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// parseStanServerScope reads the server metric to scale on and rejects the options that
+// only apply when scaling on a channel
+func parseStanServerScope(config *ScalerConfig, meta *stanMetadata) error {
+	for _, key := range stanChannelScopeOptions {
+		if _, ok := config.TriggerMetadata[key]; ok {
+			return fmt.Errorf("%s is only supported in the '%s' scope", key, stanScopeChannel)
+		}
+	}
+
+	meta.serverMetric = stanServerMetricMessages
+	if val, ok := config.TriggerMetadata["serverMetric"]; ok {
+		switch val {
+		case stanServerMetricMessages, stanServerMetricSubscriptions:
+			meta.serverMetric = val
+		default:
+			return fmt.Errorf("serverMetric must be either '%s' or '%s', got '%s'", stanServerMetricMessages, stanServerMetricSubscriptions, val)
+		}
+	}
+
+	return nil
+}
+
+// parseStanThroughputTarget derives the lag threshold from the throughput of a replica, so the
+// HPA runs enough replicas to consume the lag within drainSeconds
+func parseStanThroughputTarget(config *ScalerConfig, meta *stanMetadata) error {
+	val, ok := config.TriggerMetadata["throughputPerReplica"]
+	if !ok {
+		return nil
+	}
+
+	throughputPerReplica, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return fmt.Errorf("throughputPerReplica parsing error %s", err.Error())
+	}
+	if throughputPerReplica <= 0 {
+		return errors.New("throughputPerReplica must be greater than 0")
+	}
+
+	drainSeconds := int64(defaultStanDrainSeconds)
+	if val, ok := config.TriggerMetadata["drainSeconds"]; ok {
+		drainSeconds, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("drainSeconds parsing error %s", err.Error())
+		}
+		if drainSeconds <= 0 {
+			return errors.New("drainSeconds must be greater than 0")
+		}
+	}
+
+	meta.throughputPerReplica = throughputPerReplica
+	meta.lagThreshold = GetThroughputTarget(throughputPerReplica, float64(drainSeconds))
+	return nil
+}
+
+// parseStanFieldPaths reads the locations of the last sequence and of the subscribers in
+// payloads reshaped by a proxy, as GJSON paths
+func parseStanFieldPaths(config *ScalerConfig, meta *stanMetadata) error {
+	for key, path := range map[string]*string{"lastSequencePath": &meta.lastSequencePath, "subscribersPath": &meta.subscribersPath} {
+		*path = ""
+		val, ok := config.TriggerMetadata[key]
+		if !ok {
+			continue
+		}
+		if val == "" || strings.ContainsAny(val, " \t\n") || strings.HasPrefix(val, ".") || strings.HasSuffix(val, ".") || strings.Contains(val, "..") {
+			return fmt.Errorf("%s %q isn't a valid path", key, val)
+		}
+		*path = val
+	}
+	return nil
+}
+
+// parseStanSubscriberMetrics reads whether the lag of each subscriber is exported, and the
+// maximum number of subscribers exported
+func parseStanSubscriberMetrics(config *ScalerConfig, meta *stanMetadata) error {
+	meta.subscriberMetrics = false
+	if val, ok := config.TriggerMetadata["subscriberMetrics"]; ok {
+		subscriberMetrics, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("subscriberMetrics parsing error %s", err.Error())
+		}
+		meta.subscriberMetrics = subscriberMetrics
+	}
+
+	meta.subscriberMetricsLimit = defaultStanSubscriberMetrics
+	if val, ok := config.TriggerMetadata["subscriberMetricsLimit"]; ok {
+		subscriberMetricsLimit, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("subscriberMetricsLimit parsing error %s", err.Error())
+		}
+		if subscriberMetricsLimit <= 0 {
+			return errors.New("subscriberMetricsLimit must be greater than 0")
+		}
+		meta.subscriberMetricsLimit = subscriberMetricsLimit
+	}
+
+	// the observation metrics are only exported every observationSampleRate polls, the
+	// scaling metric is still computed on every poll
+	meta.observationSampleRate = 1
+	if val, ok := config.TriggerMetadata["observationSampleRate"]; ok {
+		observationSampleRate, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("observationSampleRate parsing error %s", err.Error())
+		}
+		if observationSampleRate <= 0 {
+			return errors.New("observationSampleRate must be greater than 0")
+		}
+		meta.observationSampleRate = observationSampleRate
+	}
+	return nil
+}
+
+// parseStanHTTPTimeouts reads the timeout of the monitoring requests, overriding the global
+// one, and the timeouts bounding their phases, which can't exceed the timeout of the whole
+// request when it's set
+func parseStanHTTPTimeouts(config *ScalerConfig, meta *stanMetadata) error {
+	requestTimeout := config.GlobalHTTPTimeout
+	meta.timeout = 0
+	if val, ok := config.TriggerMetadata["timeout"]; ok {
+		// the timeout is either a duration or a number of milliseconds
+		timeout, err := time.ParseDuration(val)
+		if timeoutMs, msErr := strconv.ParseInt(val, 10, 64); msErr == nil {
+			timeout, err = time.Duration(timeoutMs)*time.Millisecond, nil
+		}
+		if err != nil {
+			return fmt.Errorf("timeout parsing error %s", err.Error())
+		}
+		if timeout <= 0 {
+			return errors.New("timeout must be greater than 0")
+		}
+		meta.timeout = timeout
+		requestTimeout = timeout
+	}
+
+	timeouts := []struct {
+		key     string
+		timeout *time.Duration
+	}{
+		{"responseHeaderTimeoutMs", &meta.responseHeaderTimeout},
+		{"expectContinueTimeoutMs", &meta.expectContinueTimeout},
+	}
+
+	for _, t := range timeouts {
+		*t.timeout = 0
+		val, ok := config.TriggerMetadata[t.key]
+		if !ok {
+			continue
+		}
+		timeoutMs, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s parsing error %s", t.key, err.Error())
+		}
+		if timeoutMs <= 0 {
+			return fmt.Errorf("%s must be greater than 0", t.key)
+		}
+		timeout := time.Duration(timeoutMs) * time.Millisecond
+		if requestTimeout > 0 && timeout > requestTimeout {
+			return fmt.Errorf("%s must not exceed the http timeout of %s", t.key, requestTimeout)
+		}
+		*t.timeout = timeout
+	}
+	return nil
+}
+
+// parseStanTLS reads the PEM bundle of the CA signing the certificates of the monitoring
+// endpoints, and the client certificate presented to the endpoints enforcing mutual TLS. When
+// the CA is set, the endpoints are verified against it instead of the system roots.
+func parseStanTLS(config *ScalerConfig, meta *stanMetadata) error {
+	meta.ca = ""
+	if ca := config.AuthParams["ca"]; ca != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(ca)) {
+			return errors.New("ca must hold at least one PEM encoded certificate")
+		}
+		meta.ca = ca
+	}
+
+	meta.cert = config.AuthParams["cert"]
+	meta.key = config.AuthParams["key"]
+	if meta.cert != "" && meta.key == "" {
+		return errors.New("no key given, cert requires it for client certificate authentication")
+	}
+	if meta.key != "" && meta.cert == "" {
+		return errors.New("no cert given, key requires it for client certificate authentication")
+	}
+	return nil
+}
+
+// parseStanClusterID reads the cluster ID the brokers are expected to report, and whether a
+// broker reporting another one is skipped or only logged
+func parseStanClusterID(config *ScalerConfig, meta *stanMetadata) error {
+	meta.expectedClusterID = ""
+	if val, ok := config.TriggerMetadata["expectedClusterId"]; ok {
+		if !stanClusterIDPattern.MatchString(val) {
+			return fmt.Errorf("expectedClusterId %q must only contain alphanumeric characters, '_' or '-'", val)
+		}
+		meta.expectedClusterID = val
+	}
+
+	meta.clusterIDMismatch = stanClusterIDMismatchError
+	if val, ok := config.TriggerMetadata["clusterIdMismatch"]; ok {
+		if meta.expectedClusterID == "" {
+			return errors.New("clusterIdMismatch requires expectedClusterId")
+		}
+		switch val {
+		case stanClusterIDMismatchError, stanClusterIDMismatchWarn:
+			meta.clusterIDMismatch = val
+		default:
+			return fmt.Errorf("clusterIdMismatch must be either '%s' or '%s', got '%s'", stanClusterIDMismatchError, stanClusterIDMismatchWarn, val)
+		}
+	}
+	return nil
+}
+
+// parseStanSubjectLagThresholds reads the lag thresholds of the subjects, given as a comma
+// separated list of subject:threshold pairs. Subjects without a threshold use lagThreshold.
+func parseStanSubjectLagThresholds(config *ScalerConfig, meta *stanMetadata) error {
+	val, ok := config.TriggerMetadata["subjectLagThresholds"]
+	if !ok {
+		return nil
+	}
+
+	subjects := map[string]bool{}
+	for _, subject := range meta.subjects {
+		subjects[subject] = true
+	}
+	meta.subjectLagThresholds = map[string]int64{}
+	for _, pair := range strings.Split(val, ",") {
+		subject, thresholdVal, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return fmt.Errorf("subjectLagThresholds entry %q must be in the subject:threshold format", pair)
+		}
+		if !subjects[subject] {
+			return fmt.Errorf("subjectLagThresholds entry %q refers to a subject which isn't in subject", pair)
+		}
+		if _, ok := meta.subjectLagThresholds[subject]; ok {
+			return fmt.Errorf("subjectLagThresholds has more than one threshold for subject %q", subject)
+		}
+		threshold, err := strconv.ParseInt(thresholdVal, 10, 64)
+		if err != nil {
+			return fmt.Errorf("subjectLagThresholds parsing error %s", err.Error())
+		}
+		if threshold <= 0 {
+			return fmt.Errorf("subjectLagThresholds threshold of subject %q must be greater than 0", subject)
+		}
+		meta.subjectLagThresholds[subject] = threshold
+	}
+	return nil
+}
+
+// parseStanPollingIntervals reads the bounds of the polling interval suggested to the controller.
+// Adaptive polling is only enabled when both bounds are given.
+func parseStanPollingIntervals(config *ScalerConfig, meta *stanMetadata) error {
+	minVal, minOk := config.TriggerMetadata["minPollingInterval"]
+	maxVal, maxOk := config.TriggerMetadata["maxPollingInterval"]
+	if !minOk && !maxOk {
+		return nil
+	}
+	if !minOk || !maxOk {
+		return errors.New("minPollingInterval and maxPollingInterval must be given together")
+	}
+
+	minPollingInterval, err := strconv.ParseInt(minVal, 10, 64)
+	if err != nil {
+		return fmt.Errorf("minPollingInterval parsing error %s", err.Error())
+	}
+	maxPollingInterval, err := strconv.ParseInt(maxVal, 10, 64)
+	if err != nil {
+		return fmt.Errorf("maxPollingInterval parsing error %s", err.Error())
+	}
+	if minPollingInterval <= 0 || maxPollingInterval < minPollingInterval {
+		return errors.New("minPollingInterval must be greater than 0 and not greater than maxPollingInterval")
+	}
+
+	meta.minPollingInterval = time.Duration(minPollingInterval) * time.Second
+	meta.maxPollingInterval = time.Duration(maxPollingInterval) * time.Second
+	return nil
+}
+
+// parseStanMetricOptions reads the options shaping how the lag is turned into the metric value
+func parseStanMetricOptions(config *ScalerConfig, meta *stanMetadata) error {
+	meta.lagMode = stanLagModeSequence
+	if val, ok := config.TriggerMetadata["lagMode"]; ok {
+		switch val {
+		case stanLagModeSequence, stanLagModeCount, stanLagModeOverflow, stanLagModeAckGapTrend:
+			meta.lagMode = val
+		default:
+			return fmt.Errorf("lagMode must be one of '%s', '%s', '%s' or '%s', got '%s'", stanLagModeSequence, stanLagModeCount, stanLagModeOverflow, stanLagModeAckGapTrend, val)
+		}
+	}
+
+	// the pending messages include the redeliveries of the durable subscribers, which the
+	// sequence lag misses. The message count stored in the channel reports its volume whatever
+	// the subscribers consumed.
+	meta.metric = stanMetricLag
+	if val, ok := config.TriggerMetadata["metric"]; ok {
+		switch val {
+		case stanMetricLag, stanMetricPending, stanMetricMsgCount:
+			meta.metric = val
+		default:
+			return fmt.Errorf("metric must be one of '%s', '%s' or '%s', got '%s'", stanMetricLag, stanMetricPending, stanMetricMsgCount, val)
+		}
+	}
+
+	meta.forecastSeconds = 0
+	if val, ok := config.TriggerMetadata["forecastSeconds"]; ok {
+		forecastSeconds, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("forecastSeconds parsing error %s", err.Error())
+		}
+		if forecastSeconds < 0 {
+			return errors.New("forecastSeconds must not be negative")
+		}
+		meta.forecastSeconds = forecastSeconds
+	}
+
+	meta.recentWeightWindow = 0
+	if val, ok := config.TriggerMetadata["recentWeightWindow"]; ok {
+		recentWeightWindow, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("recentWeightWindow parsing error %s", err.Error())
+		}
+		if recentWeightWindow < 1 || recentWeightWindow > stanMaxLagSamples {
+			return fmt.Errorf("recentWeightWindow must be between 1 and %d", stanMaxLagSamples)
+		}
+		meta.recentWeightWindow = recentWeightWindow
+	}
+
+	// the modes derived from the sample history report the absolute lag until it holds
+	// minSamples samples
+	meta.minSamples = 0
+	if val, ok := config.TriggerMetadata["minSamples"]; ok {
+		minSamples, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("minSamples parsing error %s", err.Error())
+		}
+		if minSamples < 1 || minSamples > stanMaxLagSamples {
+			return fmt.Errorf("minSamples must be between 1 and %d", stanMaxLagSamples)
+		}
+		meta.minSamples = minSamples
+	}
+
+	meta.metricPrecision = stanMaxMetricPrecision
+	if val, ok := config.TriggerMetadata["metricPrecision"]; ok {
+		metricPrecision, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("metricPrecision parsing error %s", err.Error())
+		}
+		if metricPrecision < 0 || metricPrecision > stanMaxMetricPrecision {
+			return fmt.Errorf("metricPrecision must be between 0 and %d", stanMaxMetricPrecision)
+		}
+		meta.metricPrecision = metricPrecision
+	}
+
+	meta.activationAcceleration = 0
+	if val, ok := config.TriggerMetadata["activationLagAcceleration"]; ok {
+		activationAcceleration, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("activationLagAcceleration parsing error %s", err.Error())
+		}
+		if activationAcceleration <= 0 {
+			return errors.New("activationLagAcceleration must be greater than 0")
+		}
+		meta.activationAcceleration = activationAcceleration
+	}
+
+	meta.anomalyFactor = 0
+	if val, ok := config.TriggerMetadata["anomalyFactor"]; ok {
+		anomalyFactor, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("anomalyFactor parsing error %s", err.Error())
+		}
+		if anomalyFactor <= 1 {
+			return errors.New("anomalyFactor must be greater than 1")
+		}
+		meta.anomalyFactor = anomalyFactor
+	}
+
+	return nil
+}
+
+// parseStanWeights reads the weights used to blend the lag with the time since the
+// subscribers last made progress. Without an ageWeight the scaler reports the plain lag.
+func parseStanWeights(config *ScalerConfig, meta *stanMetadata) error {
+	meta.ageWeight = 0
+	if val, ok := config.TriggerMetadata["ageWeight"]; ok {
+		ageWeight, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("ageWeight parsing error %s", err.Error())
+		}
+		meta.ageWeight = ageWeight
+	}
+
+	meta.lagWeight = 1 - meta.ageWeight
+	if val, ok := config.TriggerMetadata["lagWeight"]; ok {
+		lagWeight, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("lagWeight parsing error %s", err.Error())
+		}
+		meta.lagWeight = lagWeight
+	}
+
+	if meta.ageWeight < 0 || meta.lagWeight < 0 {
+		return errors.New("lagWeight and ageWeight must not be negative")
+	}
+	if math.Abs(meta.ageWeight+meta.lagWeight-1) > 1e-9 {
+		return fmt.Errorf("lagWeight and ageWeight must sum to 1, got %v", meta.ageWeight+meta.lagWeight)
+	}
+
+	return nil
+}
+
+// getSTANBaseURL returns the URL of the monitoring server of a cluster, keeping the
+// scheme of the endpoint if it has one
+func getSTANBaseURL(useHTTPS bool, natsServerEndpoint string) string {
+	natsServerEndpoint = strings.TrimSuffix(natsServerEndpoint, "/")
+	if scheme, host, found := strings.Cut(natsServerEndpoint, "://"); found {
+		return fmt.Sprintf("%s://%s", scheme, bracketIPv6Host(host))
+	}
+
+	protocol := natsStreamingHTTPProtocol
+	if useHTTPS {
+		protocol = natsStreamingHTTPSProtocol
+	}
+	return fmt.Sprintf("%s://%s", protocol, bracketIPv6Host(natsServerEndpoint))
+}
+
+// bracketIPv6Host wraps a raw IPv6 literal in brackets as RFC 3986 requires. A raw literal
+// can't carry a port, since fd00::1:8222 is an address on its own, so a port is given with
+// the bracketed form [fd00::1]:8222, which is left as is.
+func bracketIPv6Host(host string) string {
+	if strings.Contains(host, ":") && net.ParseIP(host) != nil {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+func getSTANChannelsEndpoint(useHTTPS bool, monitoringPath string, natsServerEndpoint string) string {
+	return getSTANBaseURL(useHTTPS, natsServerEndpoint) + monitoringPath
+}
+
+// getSTANServerzEndpoint returns the serverz endpoint, which sits next to the channelsz one
+func getSTANServerzEndpoint(useHTTPS bool, monitoringPath string, natsServerEndpoint string) string {
+	return getSTANBaseURL(useHTTPS, natsServerEndpoint) + path.Join(path.Dir(monitoringPath), "serverz")
+}
+
+func getMonitoringEndpoint(stanChannelsEndpoint string, subject string) string {
+	return fmt.Sprintf("%s?channel=%s&subs=1", stanChannelsEndpoint, subject)
+}
+
+// matchesSubscriber reports whether a subscriber belongs to the queue group, as configured by
+// matchMode. The durable name of a plain durable subscriber isn't listed, so durableOnly
+// matches any of them.
+func (s *stanScaler) matchesSubscriber(subs monitorSubscriberInfo) bool {
+	queueName := subs.QueueName
+	switch s.metadata.matchMode {
+	case stanMatchModeQueueOnly:
+		return queueName == s.metadata.queueGroup || strings.HasSuffix(queueName, ":"+s.metadata.queueGroup)
+	case stanMatchModeDurableOnly:
+		return strings.HasPrefix(queueName, s.metadata.durableName+":") || (queueName == "" && subs.IsDurable)
+	default:
+		return queueName == s.metadata.durableName+":"+s.metadata.queueGroup
+	}
+}
+
+// getQueueSubscribers returns the subscribers of the queue group, leaving out the clients
+// listed in excludeClientIds, or all but clientID when it's set. With dedupeSubscribers,
+// entries repeated for the same client ID and inbox are only returned once.
+func (s *stanScaler) getQueueSubscribers(channelInfo *monitorChannelInfo) []monitorSubscriberInfo {
+	seen := map[string]bool{}
+	var subscribers []monitorSubscriberInfo
+
+	for _, subs := range channelInfo.Subscriber {
+		if !s.matchesSubscriber(subs) || s.metadata.excludeClientIDs[subs.ClientID] {
+			continue
+		}
+		if s.metadata.clientID != "" && subs.ClientID != s.metadata.clientID {
+			continue
+		}
+		if s.metadata.dedupeSubscribers {
+			key := subs.ClientID + "/" + subs.Inbox
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		subscribers = append(subscribers, subs)
+	}
+
+	return subscribers
+}
+
+func (s *stanScaler) getMaxLastSent(channelInfo *monitorChannelInfo) int64 {
+	maxValue := int64(0)
+
+	for _, subs := range s.getQueueSubscribers(channelInfo) {
+		if subs.LastSent > maxValue {
+			maxValue = subs.LastSent
+		}
+	}
+
+	return maxValue
+}
+
+func (s *stanScaler) getMaxMsgLag(channelInfo *monitorChannelInfo) int64 {
+	if len(s.getQueueSubscribers(channelInfo)) == 0 {
+		s.logMissingSubscription(channelInfo)
+	}
+
+	lag := s.getChannelLag(channelInfo)
+	if s.metadata.maxLag > 0 && lag > s.metadata.maxLag {
+		s.logger.Info("Warning: the stan lag exceeds maxLag, clamping it", "channel", channelInfo.Name, "lastSequence", channelInfo.LastSequence, "lag", lag, "maxLag", s.metadata.maxLag)
+		lag = s.metadata.maxLag
+	}
+	if s.metadata.inflightAware {
+		lag = s.getInflightLag(channelInfo, lag)
+	}
+	return lag
+}
+
+// getInflightLag expresses the lag in inflight windows, dividing it by the max inflight of the
+// subscribers of the queue group, rounded up. The lag is left as is when no subscriber reports
+// its max inflight.
+func (s *stanScaler) getInflightLag(channelInfo *monitorChannelInfo, lag int64) int64 {
+	maxInflight := 0
+	for _, subs := range s.getQueueSubscribers(channelInfo) {
+		if subs.MaxInflight > maxInflight {
+			maxInflight = subs.MaxInflight
+		}
+	}
+	if maxInflight == 0 {
+		s.logger.V(1).Info("Stan scaler: No max inflight reported, keeping the lag in messages", "channel", channelInfo.Name)
+		return lag
+	}
+	return int64(math.Ceil(float64(lag) / float64(maxInflight)))
+}
+
+// getChannelLag returns the lag of a channel according to the metric and lagMode
+func (s *stanScaler) getChannelLag(channelInfo *monitorChannelInfo) int64 {
+	switch s.metadata.metric {
+	case stanMetricPending:
+		return s.getPendingCount(channelInfo)
+	case stanMetricMsgCount:
+		return channelInfo.MsgCount
+	}
+	switch s.metadata.lagMode {
+	case stanLagModeCount:
+		return s.getMsgCountLag(channelInfo)
+	case stanLagModeOverflow:
+		return s.getOverflowLag(channelInfo)
+	}
+
+	// the last sent sequence can briefly exceed the last sequence after a channel reset
+	lag := channelInfo.LastSequence - s.getMaxLastSent(channelInfo)
+	if lag < 0 {
+		s.logger.V(1).Info("Stan scaler: Clamping negative lag to zero", "channel", channelInfo.Name, "lastSequence", channelInfo.LastSequence, "lag", lag)
+		return 0
+	}
+	return lag
+}
+
+// logMissingSubscription reports a channel without any subscriber of the queue group, along with
+// the queue names subscribed to it, so a misconfigured durableName or queueGroup can be spotted.
+// The lag is then the whole channel.
+func (s *stanScaler) logMissingSubscription(channelInfo *monitorChannelInfo) {
+	combinedQueueName := s.metadata.durableName + ":" + s.metadata.queueGroup
+	seen := map[string]bool{}
+	queueNames := []string{}
+	for _, subs := range channelInfo.Subscriber {
+		if !seen[subs.QueueName] {
+			seen[subs.QueueName] = true
+			queueNames = append(queueNames, subs.QueueName)
+		}
+	}
+	s.logger.Info("No STAN subscriber matches the queue group", "channel", channelInfo.Name, "combinedQueueName", combinedQueueName, "matchMode", s.metadata.matchMode, "observedQueueNames", queueNames)
+}
+
+// getPendingCount returns the messages sent to the subscribers of the queue group and not
+// acknowledged yet
+func (s *stanScaler) getPendingCount(channelInfo *monitorChannelInfo) int64 {
+	var pending int64
+	for _, subscriber := range s.getQueueSubscribers(channelInfo) {
+		pending += int64(subscriber.PendingCount)
+	}
+	return pending
+}
+
+// getOverflowLag returns the pending messages beyond the max inflight of each subscriber, which
+// are queued for redelivery. Subscribers which don't report their max inflight are skipped.
+func (s *stanScaler) getOverflowLag(channelInfo *monitorChannelInfo) int64 {
+	var overflow int64
+	for _, subscriber := range s.getQueueSubscribers(channelInfo) {
+		if subscriber.MaxInflight <= 0 {
+			s.logger.V(1).Info("Skipping STAN subscriber without max inflight", "clientID", subscriber.ClientID)
+			continue
+		}
+		if subscriber.PendingCount > subscriber.MaxInflight {
+			overflow += int64(subscriber.PendingCount - subscriber.MaxInflight)
+		}
+	}
+	return overflow
+}
+
+// getMsgCountLag returns the messages stored in the channel minus the ones the queue group
+// consumed. Subscribers that didn't consume any stored message yet count as no progress.
+func (s *stanScaler) getMsgCountLag(channelInfo *monitorChannelInfo) int64 {
+	firstSequence := channelInfo.LastSequence - channelInfo.MsgCount + 1
+	consumed := s.getMaxLastSent(channelInfo) - firstSequence + 1
+	if consumed < 0 {
+		consumed = 0
+	}
+
+	lag := channelInfo.MsgCount - consumed
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// getLagAge returns the seconds elapsed since the subscribers last advanced their
+// LastSent position while there was lag. The first poll has no previous position
+// to compare with, so the age is unknown and reported as zero.
+func (s *stanScaler) getLagAge(lastSent int64, lag int64, now time.Time) float64 {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.lastSentAdvanced.IsZero() || lastSent > s.lastSent || lag <= 0 {
+		s.lastSent = lastSent
+		s.lastSentAdvanced = now
+		return 0
+	}
+
+	return now.Sub(s.lastSentAdvanced).Seconds()
+}
+
+// getBlendedLag weights the lag with the age of the oldest unacked message. The age is
+// derived from the observed lag, as the forecasted one may be zero while messages are
+// still waiting to be acked.
+func (s *stanScaler) getBlendedLag(lag float64, observedLag int64, lastSent int64, now time.Time) float64 {
+	if s.metadata.ageWeight == 0 {
+		return lag
+	}
+
+	age := s.getLagAge(lastSent, observedLag, now)
+	return s.metadata.lagWeight*lag + s.metadata.ageWeight*age
+}
+
+// recordLagSample appends the lag to the sample history and returns a copy of it. An
+// anomalous lag is stored as the previous value, so it neither becomes the reference
+// for the next readings nor skews the forecast.
+func (s *stanScaler) recordLagSample(lag int64, now time.Time) []stanLagSample {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	s.lagSamples = append(s.lagSamples, stanLagSample{timestamp: now, lag: lag})
+	if len(s.lagSamples) > stanMaxLagSamples {
+		s.lagSamples = s.lagSamples[len(s.lagSamples)-stanMaxLagSamples:]
+	}
+	s.guardLagAnomaly(s.lagSamples)
+
+	samples := make([]stanLagSample, len(s.lagSamples))
+	copy(samples, s.lagSamples)
+	return samples
+}
+
+// guardLagAnomaly replaces the lag of the latest sample with the lag of the previous one
+// when it deviates from the median of the previous samples by more than anomalyFactor
+func (s *stanScaler) guardLagAnomaly(samples []stanLagSample) {
+	latest := &samples[len(samples)-1]
+	if s.metadata.anomalyFactor == 0 || !isLagAnomaly(samples, s.metadata.anomalyFactor) {
+		return
+	}
+
+	previous := samples[len(samples)-2].lag
+	s.logger.Info("Stan scaler: Ignoring anomalous lag reading, using the previous value", "lag", latest.lag, "previousLag", previous, "anomalyFactor", s.metadata.anomalyFactor)
+	latest.lag = previous
+}
+
+// isLagAnomaly checks whether the latest sample deviates from the median of the previous
+// ones by more than factor. Readings can't be judged until enough samples exist or while
+// the median is zero.
+func isLagAnomaly(samples []stanLagSample, factor float64) bool {
+	if len(samples) <= stanMinAnomalySamples {
+		return false
+	}
+
+	previous := make([]int64, 0, len(samples)-1)
+	for _, sample := range samples[:len(samples)-1] {
+		previous = append(previous, sample.lag)
+	}
+	sort.Slice(previous, func(i, j int) bool { return previous[i] < previous[j] })
+	median := float64(previous[len(previous)/2])
+	if len(previous)%2 == 0 {
+		median = float64(previous[len(previous)/2-1]+previous[len(previous)/2]) / 2
+	}
+	if median <= 0 {
+		return false
+	}
+
+	lag := float64(samples[len(samples)-1].lag)
+	return lag > median*factor || lag < median/factor
+}
+
+// getForecastLag returns the latest lag, the lag projected forecastSeconds ahead of the latest
+// sample when forecasting is enabled, or the weighted average of the recent samples. The
+// latest lag is returned until there are minSamples samples.
+func (s *stanScaler) getForecastLag(samples []stanLagSample) float64 {
+	if s.isWarmingUp(samples) {
+		return float64(samples[len(samples)-1].lag)
+	}
+	if s.metadata.recentWeightWindow > 0 {
+		return weightedLag(samples, s.metadata.recentWeightWindow)
+	}
+	if s.metadata.forecastSeconds == 0 {
+		return float64(samples[len(samples)-1].lag)
+	}
+
+	return forecastLag(samples, float64(s.metadata.forecastSeconds))
+}
+
+// isWarmingUp returns whether there are fewer than minSamples samples to derive the metric from
+func (s *stanScaler) isWarmingUp(samples []stanLagSample) bool {
+	return len(samples) < s.metadata.minSamples
+}
+
+// weightedLag returns the average of the last window samples, linearly weighted so the most
+// recent sample weighs the most
+func weightedLag(samples []stanLagSample, window int) float64 {
+	if len(samples) > window {
+		samples = samples[len(samples)-window:]
+	}
+
+	var sum, weights float64
+	for i, sample := range samples {
+		weight := float64(i + 1)
+		sum += weight * float64(sample.lag)
+		weights += weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
+
+// forecastLag fits a line through the samples using least squares and extrapolates
+// it forecastSeconds past the latest sample. Negative projections are clamped to zero.
+func forecastLag(samples []stanLagSample, forecastSeconds float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	latest := samples[len(samples)-1]
+	if len(samples) == 1 {
+		return math.Max(float64(latest.lag), 0)
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.timestamp.Sub(samples[0].timestamp).Seconds()
+		y := float64(sample.lag)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	n := float64(len(samples))
+	slope := 0.0
+	if denominator := n*sumXX - sumX*sumX; denominator != 0 {
+		slope = (n*sumXY - sumX*sumY) / denominator
+	}
+	intercept := (sumY - slope*sumX) / n
+
+	x := latest.timestamp.Sub(samples[0].timestamp).Seconds() + forecastSeconds
+	return math.Max(intercept+slope*x, 0)
+}
+
+// lagAcceleration estimates the change of the lag rate, in messages per second squared,
+// from the second difference of the last three samples. The first two polls don't have
+// enough history and report no acceleration.
+func lagAcceleration(samples []stanLagSample) float64 {
+	if len(samples) < 3 {
+		return 0
+	}
+
+	s0, s1, s2 := samples[len(samples)-3], samples[len(samples)-2], samples[len(samples)-1]
+	d1 := s1.timestamp.Sub(s0.timestamp).Seconds()
+	d2 := s2.timestamp.Sub(s1.timestamp).Seconds()
+	if d1 <= 0 || d2 <= 0 {
+		return 0
+	}
+
+	rate1 := float64(s1.lag-s0.lag) / d1
+	rate2 := float64(s2.lag-s1.lag) / d2
+	return (rate2 - rate1) / ((d1 + d2) / 2)
+}
+
+// isAccelerating returns whether the lag accelerates faster than activationLagAcceleration
+func (s *stanScaler) isAccelerating(samples []stanLagSample) bool {
+	if s.metadata.activationAcceleration == 0 {
+		return false
+	}
+
+	acceleration := lagAcceleration(samples)
+	if acceleration <= s.metadata.activationAcceleration {
+		return false
+	}
+	s.logger.V(1).Info("Stan scaler: Lag is accelerating", "acceleration", acceleration, "activationLagAcceleration", s.metadata.activationAcceleration)
+	return true
+}
+
+func (s *stanScaler) getSubscriberCount(channelInfo *monitorChannelInfo) int64 {
+	return int64(len(s.getQueueSubscribers(channelInfo)))
+}
+
+// applyWarmPoolFloor raises the metric value so that an AverageValue target keeps at least
+// warmPoolReplicas replicas while the queue group has subscribers
+func (s *stanScaler) applyWarmPoolFloor(metricValue float64, subscribers int64) float64 {
+	if s.metadata.warmPoolReplicas == 0 || subscribers == 0 {
+		return metricValue
+	}
+
+	return math.Max(metricValue, float64(s.metadata.warmPoolReplicas*s.metadata.lagThreshold))
+}
+
+func (s *stanScaler) hasPendingMessage(channelInfo *monitorChannelInfo) bool {
+	// a missing subscription is reported by getMaxMsgLag
+	for _, subs := range s.getQueueSubscribers(channelInfo) {
+		if subs.PendingCount > 0 {
+			return true
+		}
+
+		break
+	}
+
+	return false
+}
+
+// getOfflineClientIDs returns the client IDs of the subscribers of the queue group when they
+// are all offline, in which case the channel isn't consumed. It returns nil if a subscriber is
+// online or the queue group has none.
+func (s *stanScaler) getOfflineClientIDs(channelInfo *monitorChannelInfo) []string {
+	var clientIDs []string
+	for _, subs := range s.getQueueSubscribers(channelInfo) {
+		if !subs.IsOffline {
+			return nil
+		}
+		clientIDs = append(clientIDs, subs.ClientID)
+	}
+	return clientIDs
+}
+
+// hasStalledSubscriber reports whether a subscriber of the queue group is stalled, in which case
+// messages pile up even when its pending count reads zero
+func (s *stanScaler) hasStalledSubscriber(channelInfo *monitorChannelInfo) bool {
+	for _, subs := range s.getQueueSubscribers(channelInfo) {
+		if subs.IsStalled {
+			return true
+		}
+	}
+	return false
+}
+
+// stanSubjectPatternReplacer turns the wildcards of subjectPattern into metric name characters
+var stanSubjectPatternReplacer = strings.NewReplacer("*", "x", "?", "x", "[", "", "]", "", "^", "", "\\", "")
+
+// getStanMetricName returns the name of the metric, including the scaler index
+func getStanMetricName(meta stanMetadata) string {
+	// the full name keeps the characters replaced in the metric name, so the names they are
+	// replaced in can be told apart
+	fullName := fmt.Sprintf("stan-%s", strings.Join(meta.subjects, "-"))
+	if meta.subjectPattern != "" {
+		fullName = fmt.Sprintf("stan-%s", meta.subjectPattern)
+	}
+	if meta.queueGroupMetricName && meta.queueGroup != "" {
+		fullName = fmt.Sprintf("%s-%s", fullName, meta.queueGroup)
+	}
+	if meta.scope == stanScopeServer {
+		fullName = "stan-server"
+	}
+	if meta.namespacedMetricName {
+		fullName = GenerateMetricNameWithNamespace(meta.namespace, fullName)
+	}
+	fullName = GenerateMetricNameWithSuffix(fullName, meta.metricNameSuffix)
+	metricName := kedautil.NormalizeString(stanSubjectPatternReplacer.Replace(fullName))
+	indexedName := GenerateMetricNameWithIndex(meta.scalerIndex, metricName)
+	return GenerateMetricNameWithLengthLimit(indexedName, fullName)
+}
+
+func (s *stanScaler) GetMetricSpecForScaling(context.Context) []v2.MetricSpec {
+	externalMetric := &v2.ExternalMetricSource{
+		Metric: v2.MetricIdentifier{
+			Name: getStanMetricName(s.metadata),
+		},
+		Target: GetMetricTarget(s.metricType, s.metadata.lagThreshold),
+	}
+	metricSpec := v2.MetricSpec{
+		External: externalMetric, Type: stanMetricType,
+	}
+	return []v2.MetricSpec{metricSpec}
+}
+
+// getChannelInfo queries the monitoring endpoint of a cluster for a subject and decodes the
+// response. It returns nil if the channel doesn't exist on the cluster.
+func (s *stanScaler) getChannelInfo(ctx context.Context, endpoint stanEndpoint, subject string) (*monitorChannelInfo, error) {
+	monitoringEndpoint := getMonitoringEndpoint(endpoint.stanChannelsEndpoint, subject)
+	resp, body, err := s.getMonitoringResponse(ctx, monitoringEndpoint)
+	if err != nil {
+		s.recordMonitorRequest(stanOutcomeConnectionError)
+		s.logger.Error(err, "Unable to access the nats streaming broker monitoring endpoint", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		ctx, cancel := s.withRequestTimeout(ctx)
+		defer cancel()
+		req, err := s.newMonitoringRequest(ctx, endpoint.stanChannelsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		baseResp, err := s.httpClient.Do(req)
+		if err != nil {
+			s.recordMonitorRequest(stanOutcomeConnectionError)
+			return nil, categorizeStanRequestError(err)
+		}
+		defer baseResp.Body.Close()
+		if baseResp.StatusCode == 404 {
+			s.logger.Info("Streaming broker endpoint returned 404. Please ensure it has been created", "url", monitoringEndpoint, "channelName", subject)
+		} else {
+			s.logger.Info("Unable to connect to STAN. Please ensure you have configured the ScaledObject with the correct endpoint.", "baseResp.StatusCode", baseResp.StatusCode, "monitoringEndpoint", monitoringEndpoint)
+		}
+
+		// the monitoring endpoint answered, the channel doesn't exist
+		s.recordMonitorRequest(stanOutcomeSuccess)
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		s.recordMonitorRequest(stanOutcomeHTTPError)
+		err := newStanStatusError("monitoring", resp.StatusCode)
+		s.logger.Error(err, "Unexpected response from the nats streaming broker monitoring endpoint", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	if err := kedautil.CheckResponseContentType(resp, s.metadata.contentTypes, s.metadata.allowMissingContentType); err != nil {
+		s.recordMonitorRequest(stanOutcomeDecodeError)
+		s.logger.Error(err, "Unexpected response from the nats streaming broker monitoring endpoint", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	channelInfo := &monitorChannelInfo{}
+	err = json.Unmarshal(body, channelInfo)
+	if err == nil {
+		err = applyStanFieldPaths(body, channelInfo, s.metadata)
+	}
+	if err != nil {
+		s.recordMonitorRequest(stanOutcomeDecodeError)
+		err = newStanDecodeError(err, body)
+		s.logger.Error(err, "Unable to decode channel info", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	s.recordMonitorRequest(stanOutcomeSuccess)
+	return channelInfo, nil
+}
+
+// getSubjects returns the configured subjects, or the channels matching subjectPattern on any
+// of the endpoints
+func (s *stanScaler) getSubjects(ctx context.Context, endpoints []stanEndpoint) ([]string, error) {
+	if s.metadata.subjectPattern == "" {
+		return s.metadata.subjects, nil
+	}
+
+	seen := map[string]bool{}
+	subjects := []string{}
+	reachable := 0
+	var lastErr error
+	for _, endpoint := range endpoints {
+		names, err := s.getChannelNames(ctx, endpoint)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reachable++
+		listed := map[string]bool{}
+		for _, name := range names {
+			if matched, _ := path.Match(s.metadata.subjectPattern, name); matched {
+				listed[name] = true
+				if !seen[name] {
+					seen[name] = true
+					subjects = append(subjects, name)
+				}
+			}
+		}
+		s.pruneChannelInfos(endpoint, listed)
+	}
+	if reachable == 0 {
+		return nil, lastErr
+	}
+	sort.Strings(subjects)
+	return subjects, nil
+}
+
+// getChannelNames lists the channels of a cluster, following the pages of the channelsz endpoint
+func (s *stanScaler) getChannelNames(ctx context.Context, endpoint stanEndpoint) ([]string, error) {
+	var names []string
+	for {
+		channelList, err := s.getChannelList(ctx, fmt.Sprintf("%s?offset=%d", endpoint.stanChannelsEndpoint, len(names)))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, channelList.Names...)
+		if len(channelList.Names) == 0 || len(names) >= channelList.Total {
+			return names, nil
+		}
+	}
+}
+
+// getChannelList fetches a page of the channel names
+func (s *stanScaler) getChannelList(ctx context.Context, monitoringEndpoint string) (*monitorChannelList, error) {
+	resp, body, err := s.getMonitoringResponse(ctx, monitoringEndpoint)
+	if err != nil {
+		s.recordMonitorRequest(stanOutcomeConnectionError)
+		s.logger.Error(err, "Unable to access the nats streaming broker monitoring endpoint", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		s.recordMonitorRequest(stanOutcomeHTTPError)
+		err := newStanStatusError("monitoring", resp.StatusCode)
+		s.logger.Error(err, "Unexpected response from the nats streaming broker monitoring endpoint", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	if err := kedautil.CheckResponseContentType(resp, s.metadata.contentTypes, s.metadata.allowMissingContentType); err != nil {
+		s.recordMonitorRequest(stanOutcomeDecodeError)
+		s.logger.Error(err, "Unexpected response from the nats streaming broker monitoring endpoint", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	channelList := &monitorChannelList{}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(channelList); err != nil {
+		s.recordMonitorRequest(stanOutcomeDecodeError)
+		err = newStanDecodeError(err, body)
+		s.logger.Error(err, "Unable to decode the channel list", "monitoringEndpoint", monitoringEndpoint)
+		return nil, err
+	}
+	s.recordMonitorRequest(stanOutcomeSuccess)
+	return channelList, nil
+}
+
+// recordMonitorRequest counts a request to a monitoring endpoint by its outcome
+func (s *stanScaler) recordMonitorRequest(outcome string) {
+	prommetrics.RecordScalerMonitorRequest(s.metadata.namespace, s.scaledObject, stanScalerType, outcome)
+}
+
+// getMonitoringResponse queries a monitoring endpoint and reads the whole response, which is
+// returned along with a body replaying it. Unreachable endpoints and server errors are retried
+// up to maxRetries times with an exponential backoff. The attempts share the timeout of the
+// client, so the retries can't hold up the poll beyond it.
+func (s *stanScaler) getMonitoringResponse(ctx context.Context, monitoringURL string) (*http.Response, []byte, error) {
+	ctx, cancel := s.withRequestTimeout(ctx)
+	defer cancel()
+
+	backoff := stanRetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, body, err := s.getCompleteMonitoringResponse(ctx, monitoringURL)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			return resp, body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if err != nil && !isStanTransientError(err) {
+			return nil, nil, err
+		}
+		// a server error is left to the caller, which reports the status. A retry which can't
+		// start before the deadline isn't attempted.
+		if deadline, ok := ctx.Deadline(); attempt >= s.metadata.maxRetries || (ok && time.Until(deadline) < backoff) {
+			return resp, body, err
+		}
+
+		if err != nil {
+			s.logger.V(1).Info("Stan scaler: Retrying monitoring request", "monitoringEndpoint", monitoringURL, "attempt", attempt+1, "error", err.Error())
+		} else {
+			s.logger.V(1).Info("Stan scaler: Retrying monitoring request", "monitoringEndpoint", monitoringURL, "attempt", attempt+1, "status", resp.StatusCode)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// waitJitter delays a poll by a random duration below jitter, so the requests of many scalers
+// polling the same monitoring endpoint don't line up. It returns early when ctx is done.
+func (s *stanScaler) waitJitter(ctx context.Context) error {
+	if s.metadata.jitter <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(s.metadata.jitter))))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isStanTransientError reports whether a failed monitoring request is worth retrying, which is
+// the case when the connection to the monitoring endpoint couldn't be established or was reset.
+// DNS and TLS failures are configuration errors which fail again, and a timeout already used up
+// the time of the request. Truncated responses have their own retry.
+func isStanTransientError(err error) bool {
+	if errors.Is(err, ErrStanDNS) || errors.Is(err, ErrStanTLS) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isStanOutageError reports whether a failed monitoring request is due to the monitoring
+// endpoint being unreachable: its name couldn't be resolved, the connection failed or was cut,
+// or the request timed out
+func isStanOutageError(err error) bool {
+	if errors.Is(err, ErrStanDNS) || errors.Is(err, errStanTruncatedResponse) || errors.Is(err, context.DeadlineExceeded) || isStanTransientError(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// getCompleteMonitoringResponse requests a monitoring endpoint and returns the response with
+// its body. Truncated responses are retried, and fail with errStanTruncatedResponse once the
+// retries are exhausted.
+func (s *stanScaler) getCompleteMonitoringResponse(ctx context.Context, monitoringURL string) (*http.Response, []byte, error) {
+	var err error
+	for attempt := 0; attempt <= stanTruncatedResponseRetries; attempt++ {
+		var resp *http.Response
+		var body []byte
+		resp, body, err = s.doMonitoringRequest(ctx, monitoringURL)
+		if err == nil {
+			return resp, body, nil
+		}
+		if !errors.Is(err, errStanTruncatedResponse) {
+			return nil, nil, err
+		}
+		s.logger.Info("Warning: truncated response from the nats streaming broker", "monitoringEndpoint", monitoringURL, "attempt", attempt+1, "error", err.Error())
+	}
+	return nil, nil, err
+}
+
+// doMonitoringRequest performs a single request to a monitoring endpoint and reads the whole
+// response
+func (s *stanScaler) doMonitoringRequest(ctx context.Context, monitoringURL string) (*http.Response, []byte, error) {
+	req, err := s.newMonitoringRequest(ctx, monitoringURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, categorizeStanRequestError(err)
+	}
+	body, err := readStanResponseBody(resp)
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, body, nil
+}
+
+// withRequestTimeout bounds ctx by the timeout of the client, so a hung read can't outlast it
+// even when the caller gave no deadline
+func (s *stanScaler) withRequestTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.httpClient.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.httpClient.Timeout)
+}
+
+// checkStanRedirect follows up to 10 redirects like the default client. The Authorization
+// header of the original request is carried to redirects on the same scheme, host and port,
+// and dropped from the others.
+func checkStanRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	original := via[0]
+	if req.URL.Scheme == original.URL.Scheme && req.URL.Host == original.URL.Host {
+		if authorization := original.Header.Get("Authorization"); authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+	} else {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// newMonitoringRequest builds a request to a monitoring endpoint identified by userAgent, carrying
+// bearerToken when set
+func (s *stanScaler) newMonitoringRequest(ctx context.Context, monitoringURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", monitoringURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", s.metadata.userAgent)
+	// asking for gzip explicitly turns off the transparent decompression of the transport, the
+	// body is decompressed by readStanResponseBody instead
+	req.Header.Set("Accept-Encoding", "gzip")
+	if s.metadata.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.metadata.bearerToken)
+	}
+	return req, nil
+}
+
+// readStanResponseBody reads the body of a monitoring response, decompressing gzip encoded
+// bodies. A body shorter than its Content-Length, or a successful response which doesn't hold a
+// complete JSON value, is reported as truncated. Bodies which aren't JSON at all are left to the
+// content type check.
+func readStanResponseBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: %s", errStanTruncatedResponse, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength >= 0 && int64(len(body)) != resp.ContentLength {
+		return nil, fmt.Errorf("%w: read %d bytes, expected %d", errStanTruncatedResponse, len(body), resp.ContentLength)
+	}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") && len(body) > 0 {
+		if body, err = gunzipStanResponseBody(body); err != nil {
+			return nil, err
+		}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = int64(len(body))
+		resp.Uncompressed = true
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, nil
+	}
+
+	var value json.RawMessage
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&value)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: incomplete JSON body", errStanTruncatedResponse)
+	}
+	return body, nil
+}
+
+// newStanDecodeError adds the start of the body to an error decoding it, up to
+// stanBodySnippetLength bytes
+func newStanDecodeError(err error, body []byte) error {
+	snippet := body
+	if len(snippet) > stanBodySnippetLength {
+		snippet = snippet[:stanBodySnippetLength]
+	}
+	return fmt.Errorf("%w, body: %q", err, snippet)
+}
+
+// gunzipStanResponseBody decompresses a gzip encoded body, a cut compressed stream being
+// reported as truncated
+func gunzipStanResponseBody(body []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing the gzip response: %w", err)
+	}
+	defer reader.Close()
+	decompressed, err := io.ReadAll(reader)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: %s", errStanTruncatedResponse, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing the gzip response: %w", err)
+	}
+	return decompressed, nil
+}
+
+// applyStanFieldPaths reads the last sequence and the subscribers from the locations given by
+// lastSequencePath and subscribersPath, for payloads reshaped by a proxy
+func applyStanFieldPaths(body []byte, channelInfo *monitorChannelInfo, meta stanMetadata) error {
+	if meta.lastSequencePath != "" {
+		r := gjson.GetBytes(body, meta.lastSequencePath)
+		if r.Type != gjson.Number {
+			return fmt.Errorf("lastSequencePath %q doesn't point to a number in the channel info", meta.lastSequencePath)
+		}
+		channelInfo.LastSequence = r.Int()
+	}
+
+	if meta.subscribersPath != "" {
+		r := gjson.GetBytes(body, meta.subscribersPath)
+		if !r.IsArray() {
+			return fmt.Errorf("subscribersPath %q doesn't point to an array in the channel info", meta.subscribersPath)
+		}
+		var subscribers []monitorSubscriberInfo
+		if err := json.Unmarshal([]byte(r.Raw), &subscribers); err != nil {
+			return err
+		}
+		channelInfo.Subscriber = subscribers
+	}
+	return nil
+}
+
+// getServerInfo queries the serverz endpoint of a cluster. It returns nil if the broker
+// doesn't expose the endpoint, as older brokers do.
+func (s *stanScaler) getServerInfo(ctx context.Context, endpoint stanEndpoint) (*monitorServerInfo, error) {
+	resp, body, err := s.getMonitoringResponse(ctx, endpoint.serverzEndpoint)
+	if err != nil {
+		s.recordMonitorRequest(stanOutcomeConnectionError)
+		s.logger.Error(err, "Unable to access the nats streaming broker serverz endpoint", "serverzEndpoint", endpoint.serverzEndpoint)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		s.recordMonitorRequest(stanOutcomeSuccess)
+		s.logger.Info("Streaming broker serverz endpoint returned 404. Please ensure the broker version exposes it", "url", endpoint.serverzEndpoint)
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		s.recordMonitorRequest(stanOutcomeHTTPError)
+		err := newStanStatusError("serverz", resp.StatusCode)
+		s.logger.Error(err, "Unexpected response from the nats streaming broker serverz endpoint", "serverzEndpoint", endpoint.serverzEndpoint)
+		return nil, err
+	}
+	if err := kedautil.CheckResponseContentType(resp, s.metadata.contentTypes, s.metadata.allowMissingContentType); err != nil {
+		s.recordMonitorRequest(stanOutcomeDecodeError)
+		s.logger.Error(err, "Unexpected response from the nats streaming broker serverz endpoint", "serverzEndpoint", endpoint.serverzEndpoint)
+		return nil, err
+	}
+	serverInfo := &monitorServerInfo{}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(serverInfo); err != nil {
+		s.recordMonitorRequest(stanOutcomeDecodeError)
+		err = newStanDecodeError(err, body)
+		s.logger.Error(err, "Unable to decode server info", "serverzEndpoint", endpoint.serverzEndpoint)
+		return nil, err
+	}
+	s.recordMonitorRequest(stanOutcomeSuccess)
+	return serverInfo, nil
+}
+
+// getServerMetricsAndActivity scales on the total number of messages stored by the brokers,
+// or on the total number of subscriptions
+func (s *stanScaler) getServerMetricsAndActivity(ctx context.Context, metricName string) ([]external_metrics.ExternalMetricValue, bool, error) {
+	endpoints, err := s.getEndpoints(ctx)
+	if err != nil {
+		s.healthTracker.RecordFailure()
+		return []external_metrics.ExternalMetricValue{}, false, err
+	}
+
+	var totalMsgs, subscriptions int64
+	reachable, found := 0, 0
+	var lastErr error
+
+	for _, endpoint := range endpoints {
+		serverInfo, err := s.getServerInfo(ctx, endpoint)
+		if err == nil {
+			err = s.checkClusterID(endpoint, serverInfo)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reachable++
+		if serverInfo == nil {
+			continue
+		}
+		found++
+		totalMsgs += serverInfo.TotalMsgs
+		subscriptions += serverInfo.Subscriptions
+	}
+
+	if reachable == 0 {
+		s.healthTracker.RecordFailure()
+		return []external_metrics.ExternalMetricValue{}, false, lastErr
+	}
+	s.healthTracker.RecordSuccess(s.metadata.minSuccessesBeforeTrust)
+	s.recordFirstPoll()
+	if found == 0 {
+		return []external_metrics.ExternalMetricValue{}, false, nil
+	}
+
+	value := totalMsgs
+	if s.metadata.serverMetric == stanServerMetricSubscriptions {
+		value = subscriptions
+	}
+	s.updatePollingInterval(value == 0)
+
+	s.logger.V(1).Info("Stan scaler: Providing metrics based on the server totals", "serverMetric", s.metadata.serverMetric, "totalMsgs", totalMsgs, "subscriptions", subscriptions, "lagThreshold", s.metadata.lagThreshold)
+	metric := GenerateMetricInMiliWithPrecision(metricName, s.holdMetricValue(float64(value)), s.metadata.metricPrecision)
+
+	return []external_metrics.ExternalMetricValue{metric}, value > s.metadata.activationLagThreshold, nil
+}
+
+// GetMetricsAndActivity returns value for a supported metric and an error if there is a problem getting the metric
+func (s *stanScaler) GetMetricsAndActivity(ctx context.Context, metricName string) ([]external_metrics.ExternalMetricValue, bool, error) {
+	// a cancelled poll fails anyway, without reaching the monitoring endpoint
+	if err := ctx.Err(); err != nil {
+		return []external_metrics.ExternalMetricValue{}, false, err
+	}
+	s.recordThreshold(metricName)
+	if err := s.waitJitter(ctx); err != nil {
+		return []external_metrics.ExternalMetricValue{}, false, err
+	}
+	if s.metadata.scope == stanScopeServer {
+		return s.getServerMetricsAndActivity(ctx, metricName)
+	}
+
+	endpoints, err := s.getVerifiedEndpoints(ctx)
+	if len(endpoints) == 0 {
+		s.healthTracker.RecordFailure()
+		return []external_metrics.ExternalMetricValue{}, false, err
+	}
+
+	exportObservations := s.sampleObservations()
+	var totalLag, lastSent, subscribers, lastSequence, backlog int64
+	var normalizedLag, normalizedLagSum float64
+	ackGaps := map[string]int64{}
+	exportedSubscribers := map[string]bool{}
+	hasPendingMessage, stalled := false, false
+	var offlineLag, unconsumedSequence int64
+	reachable, found := 0, 0
+	var lastErr error
+
+	subjects, err := s.getSubjects(ctx, endpoints)
+	if err != nil {
+		s.healthTracker.RecordFailure()
+		return []external_metrics.ExternalMetricValue{}, false, err
+	}
+	if len(subjects) == 0 {
+		s.logger.Info("No STAN channel matches the subject pattern", "subjectPattern", s.metadata.subjectPattern)
+		s.healthTracker.RecordSuccess(s.metadata.minSuccessesBeforeTrust)
+		s.recordFirstPoll()
+		if exportObservations {
+			s.pruneSubscriberLags(nil)
+		}
+		return []external_metrics.ExternalMetricValue{}, false, nil
+	}
+
+	for _, subject := range subjects {
+		state, subjectReachable, err := s.getChannelState(ctx, endpoints, subject, exportObservations)
+		reachable += subjectReachable
+		if err != nil {
+			lastErr = err
+		}
+		if state == nil {
+			continue
+		}
+		found++
+		totalLag += state.lag
+		lastSequence += state.lastSequence
+		lastSent += state.lastSent
+		subscribers += state.subscribers
+		backlog += state.msgCount
+		hasPendingMessage = hasPendingMessage || state.hasPendingMessage
+		stalled = stalled || state.stalled
+		if state.offlineLag > offlineLag {
+			offlineLag = state.offlineLag
+		}
+		if state.unconsumedSequence > unconsumedSequence {
+			unconsumedSequence = state.unconsumedSequence
+		}
+		for key, gap := range state.ackGaps {
+			ackGaps[key] = gap
+		}
+		for _, clientID := range state.exportedSubscribers {
+			exportedSubscribers[subject+"/"+clientID] = true
+		}
+		subjectLag := float64(state.lag) / float64(s.getSubjectLagThreshold(subject))
+		normalizedLag = math.Max(normalizedLag, subjectLag)
+		normalizedLagSum += subjectLag
+	}
+
+	// the poll succeeds as long as one cluster answered
+	if reachable == 0 {
+		s.healthTracker.RecordFailure()
+		return []external_metrics.ExternalMetricValue{}, false, lastErr
+	}
+	s.healthTracker.RecordSuccess(s.metadata.minSuccessesBeforeTrust)
+	s.recordFirstPoll()
+	if exportObservations {
+		s.pruneSubscriberLags(exportedSubscribers)
+	}
+	if found == 0 {
+		return []external_metrics.ExternalMetricValue{}, false, nil
+	}
+
+	if s.metadata.lagMode == stanLagModeAckGapTrend {
+		trend := s.getAckGapTrend(ackGaps, time.Now())
+		s.logger.V(1).Info("Stan scaler: Providing metrics based on the ack gap trend", "trend", trend, "lagThreshold", s.metadata.lagThreshold)
+		metric := GenerateMetricInMiliWithPrecision(metricName, s.holdMetricValue(math.Max(trend, 0)), s.metadata.metricPrecision)
+		return []external_metrics.ExternalMetricValue{metric}, trend > 0, nil
+	}
+
+	// a backlog without any subscriber of the queue group scales from zero, whatever the lag
+	// mode or metric reports
+	unconsumedActive := unconsumedSequence > s.metadata.activationLagThreshold
+
+	// the activity still follows the lag, as the first poll has no rate yet
+	if s.metadata.metricMode == stanMetricModeRate {
+		rate := s.getPublishRate(lastSequence, time.Now())
+		s.logger.V(1).Info("Stan scaler: Providing metrics based on the publish rate", "rate", rate, "lastSequence", lastSequence, "lagThreshold", s.metadata.lagThreshold)
+		metric := GenerateMetricInMiliWithPrecision(metricName, s.holdMetricValue(rate), s.metadata.metricPrecision)
+		return []external_metrics.ExternalMetricValue{metric}, unconsumedActive || hasPendingMessage || totalLag > s.metadata.activationLagThreshold, nil
+	}
+
+	// with several subjects the lag reported is the highest lag relative to the threshold of
+	// its subject, or the sum of the relative lags, expressed in units of lagThreshold
+	if len(subjects) > 1 {
+		if s.metadata.aggregation == stanAggregationSum {
+			normalizedLag = normalizedLagSum
+		}
+		totalLag = int64(math.Ceil(normalizedLag * float64(s.metadata.lagThreshold)))
+	}
+
+	// the lags clamped on each cluster and channel can still add up beyond maxLag
+	if s.metadata.maxLag > 0 && totalLag > s.metadata.maxLag {
+		s.logger.Info("Warning: the combined stan lag exceeds maxLag, clamping it", "totalLag", totalLag, "maxLag", s.metadata.maxLag)
+		totalLag = s.metadata.maxLag
+	}
+
+	now := time.Now()
+	samples := s.recordLagSample(totalLag, now)
+	totalLag = samples[len(samples)-1].lag
+	metricValue := s.getBlendedLag(s.getForecastLag(samples), totalLag, lastSent, now)
+	metricValue = s.holdMetricValue(s.applyWarmPoolFloor(metricValue, subscribers))
+	metricValue = s.applyModeCanary(metricValue, lastSequence, s.isWarmingUp(samples), now)
+	lagStuck := s.isLagStuck(totalLag, now)
+	if lagStuck {
+		s.logger.Info("Warning: the stan lag hasn't decreased for maxLagAgeSeconds, scaling to the max replicas", "totalLag", totalLag, "maxLagAgeSeconds", s.metadata.maxLagAge.Seconds())
+		metricValue = float64(s.metadata.lagThreshold) * stanEmergencyReplicas
+	}
+	s.logger.V(1).Info("Stan scaler: Providing metrics based on totalLag, threshold", "totalLag", totalLag, "metricValue", metricValue, "lagThreshold", s.metadata.lagThreshold)
+	if s.dryRun {
+		s.logDryRunRecommendation(totalLag, metricValue)
+	}
+
+	metric := GenerateMetricInMiliWithPrecision(metricName, metricValue, s.metadata.metricPrecision)
+
+	// the warm pool is kept while subscribers exist, even without lag
+	warmPoolActive := s.metadata.warmPoolReplicas > 0 && subscribers > 0
+
+	s.updatePollingInterval(totalLag == 0 && !hasPendingMessage)
+
+	// a stalled subscriber activates the scaler even when the lag is below activationLagThreshold
+	stalledActive := s.metadata.scaleOnStalled && stalled
+	if stalledActive {
+		s.logger.V(1).Info("Stan scaler: A subscriber is stalled", "totalLag", totalLag, "activationLagThreshold", s.metadata.activationLagThreshold)
+	}
+
+	// the sequence lag of a channel whose subscribers are all offline keeps growing, whatever
+	// the lag mode or metric reports
+	offlineActive := offlineLag > s.metadata.activationLagThreshold
+
+	lagActive := hasPendingMessage || totalLag > s.metadata.activationLagThreshold
+	switch s.metadata.metric {
+	case stanMetricPending:
+		// the pending messages are compared to their own threshold, so a stray pending message
+		// doesn't scale from zero
+		lagActive = totalLag > s.metadata.activationPendingThreshold
+	case stanMetricMsgCount:
+		lagActive = totalLag > s.metadata.activationLagThreshold
+	}
+
+	active := lagStuck || warmPoolActive || stalledActive || offlineActive || unconsumedActive || lagActive || s.isAccelerating(samples)
+	active = s.applySubscriptionGrace(active, subscribers, backlog, now)
+	return []external_metrics.ExternalMetricValue{metric}, s.applyQuietPeriod(active, now), nil
+}
+
+// getAckGapTrend records the ack gap of each subscriber and returns how fast the gaps widen, in
+// messages per second, summed across the subscribers. Subscribers which disappeared are pruned,
+// and new ones don't contribute until they have been seen twice.
+func (s *stanScaler) getAckGapTrend(ackGaps map[string]int64, now time.Time) float64 {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.ackGaps == nil {
+		s.ackGaps = map[string][]stanLagSample{}
+	}
+	for key := range s.ackGaps {
+		if _, ok := ackGaps[key]; !ok {
+			delete(s.ackGaps, key)
+		}
+	}
+
+	var trend float64
+	for key, gap := range ackGaps {
+		history := append(s.ackGaps[key], stanLagSample{timestamp: now, lag: gap})
+		if len(history) > stanMaxLagSamples {
+			history = history[len(history)-stanMaxLagSamples:]
+		}
+		s.ackGaps[key] = history
+
+		first, last := history[0], history[len(history)-1]
+		if elapsed := last.timestamp.Sub(first.timestamp).Seconds(); elapsed > 0 {
+			trend += float64(last.lag-first.lag) / elapsed
+		}
+	}
+	return trend
+}
+
+// applyModeCanary computes the publish rate alongside the lag when modeCanary is set. One of
+// them is reported and the other is only logged, to compare the modes before switching. The
+// lag is reported instead of the rate while warmingUp.
+func (s *stanScaler) applyModeCanary(lagValue float64, lastSequence int64, warmingUp bool, now time.Time) float64 {
+	if s.metadata.modeCanary == "" {
+		return lagValue
+	}
+
+	rateValue := s.getPublishRate(lastSequence, now)
+	reportedValue, shadowValue := lagValue, rateValue
+	if s.metadata.modeCanary == stanModeCanaryShadowLag && !warmingUp {
+		reportedValue, shadowValue = rateValue, lagValue
+	}
+	s.logger.Info("Stan scaler: Canary mode", "modeCanary", s.metadata.modeCanary, "reportedValue", reportedValue, "shadowValue", shadowValue)
+	return reportedValue
+}
+
+// getPublishRate returns the messages published per second since the previous poll, or zero
+// on the first poll
+func (s *stanScaler) getPublishRate(lastSequence int64, now time.Time) float64 {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	var rate float64
+	if !s.lastSequenceTime.IsZero() && now.After(s.lastSequenceTime) && lastSequence >= s.lastSequence {
+		rate = float64(lastSequence-s.lastSequence) / now.Sub(s.lastSequenceTime).Seconds()
+	}
+	s.lastSequence = lastSequence
+	s.lastSequenceTime = now
+	return rate
+}
+
+// isLagStuck returns whether the lag hasn't decreased for maxLagAgeSeconds. Any decrease of
+// the lag resets the period.
+func (s *stanScaler) isLagStuck(lag int64, now time.Time) bool {
+	if s.metadata.maxLagAge == 0 {
+		return false
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.lastLagDecrease.IsZero() || lag < s.previousLag || lag == 0 {
+		s.lastLagDecrease = now
+	}
+	s.previousLag = lag
+	return lag > 0 && now.Sub(s.lastLagDecrease) >= s.metadata.maxLagAge
+}
+
+// applyQuietPeriod keeps the scaler active until it has been inactive for quietPeriodSeconds,
+// so momentary zeros don't scale the workload to zero
+func (s *stanScaler) applyQuietPeriod(active bool, now time.Time) bool {
+	if s.metadata.quietPeriod == 0 {
+		return active
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if active {
+		s.lastActive = now
+		return true
+	}
+	return !s.lastActive.IsZero() && now.Sub(s.lastActive) < s.metadata.quietPeriod
+}
+
+// applySubscriptionGrace keeps the scaler active for subscriptionGraceSeconds after it became
+// active while the channel holds messages but the consumers haven't registered their
+// subscriptions yet, so a scale from zero doesn't flap back to zero
+func (s *stanScaler) applySubscriptionGrace(active bool, subscribers, backlog int64, now time.Time) bool {
+	if s.metadata.subscriptionGrace == 0 {
+		return active
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if active {
+		if !s.wasActive {
+			s.activatedAt = now
+		}
+		s.wasActive = true
+		return true
+	}
+	if s.wasActive && subscribers == 0 && backlog > 0 && now.Sub(s.activatedAt) < s.metadata.subscriptionGrace {
+		return true
+	}
+	s.wasActive = false
+	return false
+}
+
+// stanChannelState is the state of a channel combined across the clusters
+type stanChannelState struct {
+	lag               int64
+	lastSequence      int64
+	msgCount          int64
+	lastSent          int64
+	subscribers       int64
+	hasPendingMessage bool
+	stalled           bool
+	// offlineLag is the sequence lag of the clusters where every subscriber is offline
+	offlineLag int64
+	// unconsumedSequence is the last sequence of the clusters where the queue group has no
+	// subscriber at all
+	unconsumedSequence int64
+	// ackGaps are the messages sent but not acknowledged yet, keyed by subject and client ID
+	ackGaps map[string]int64
+	// exportedSubscribers are the client IDs whose lag was exported
+	exportedSubscribers []string
+}
+
+// getVerifiedEndpoints returns the endpoints of the clusters reporting the expected cluster ID,
+// along with the last verification error
+func (s *stanScaler) getVerifiedEndpoints(ctx context.Context) ([]stanEndpoint, error) {
+	candidates, err := s.getEndpoints(ctx)
+	if err != nil || s.metadata.expectedClusterID == "" {
+		return candidates, err
+	}
+
+	var endpoints []stanEndpoint
+	var lastErr error
+	for _, endpoint := range candidates {
+		serverInfo, err := s.getServerInfo(ctx, endpoint)
+		if err == nil {
+			err = s.checkClusterID(endpoint, serverInfo)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, lastErr
+}
+
+// getEndpoints returns the configured endpoints, or the endpoint resolved from srvRecord. The
+// record is resolved again every stanSRVRefreshInterval, and the last endpoint is kept while
+// the lookup fails.
+func (s *stanScaler) getEndpoints(ctx context.Context) ([]stanEndpoint, error) {
+	if s.metadata.srvName == "" {
+		return s.metadata.endpoints, nil
+	}
+
+	s.stateLock.Lock()
+	if s.srvEndpoint != nil && time.Since(s.srvResolvedAt) < stanSRVRefreshInterval {
+		endpoint := *s.srvEndpoint
+		s.stateLock.Unlock()
+		return []stanEndpoint{endpoint}, nil
+	}
+	s.stateLock.Unlock()
+
+	// the lookup may be slow, so it doesn't hold the lock the other polls wait on
+	endpoint, err := s.resolveSRVEndpoint(ctx)
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	if err != nil {
+		if s.srvEndpoint == nil {
+			return nil, err
+		}
+		s.logger.Info("Warning: unable to resolve the srv record, keeping the last endpoint", "srvName", s.metadata.srvName, "error", err.Error())
+		return []stanEndpoint{*s.srvEndpoint}, nil
+	}
+	s.srvEndpoint = &endpoint
+	s.srvResolvedAt = time.Now()
+	return []stanEndpoint{endpoint}, nil
+}
+
+// resolveSRVEndpoint looks up srvRecord and builds the endpoint of the selected target
+func (s *stanScaler) resolveSRVEndpoint(ctx context.Context) (stanEndpoint, error) {
+	_, records, err := s.resolver.LookupSRV(ctx, s.metadata.srvService, s.metadata.srvProto, s.metadata.srvName)
+	if err != nil {
+		return stanEndpoint{}, fmt.Errorf("error resolving srv record %s: %s", s.metadata.srvName, err)
+	}
+	target := selectSRVTarget(records)
+	if target == nil {
+		return stanEndpoint{}, fmt.Errorf("srv record %s has no target", s.metadata.srvName)
+	}
+
+	natsServerEndpoint := net.JoinHostPort(strings.TrimSuffix(target.Target, "."), strconv.Itoa(int(target.Port)))
+	useHTTPS := s.metadata.useHTTPS || inferStanHTTPS(&s.metadata, natsServerEndpoint)
+	return parseStanEndpoint(useHTTPS, s.metadata.monitoringPath, natsServerEndpoint)
+}
+
+// selectSRVTarget picks a target among the records of the lowest priority, randomly in proportion
+// to their weight as described by RFC 2782. Records of weight 0 are only picked when all the
+// records of that priority have weight 0. It returns nil if there is no usable record.
+func selectSRVTarget(records []*net.SRV) *net.SRV {
+	var candidates []*net.SRV
+	for _, record := range records {
+		// a target of "." means the service isn't available
+		if record == nil || record.Target == "" || record.Target == "." {
+			continue
+		}
+		if len(candidates) > 0 && record.Priority > candidates[0].Priority {
+			continue
+		}
+		if len(candidates) > 0 && record.Priority < candidates[0].Priority {
+			candidates = nil
+		}
+		candidates = append(candidates, record)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	totalWeight := 0
+	for _, candidate := range candidates {
+		totalWeight += int(candidate.Weight)
+	}
+	if totalWeight == 0 {
+		return candidates[0]
+	}
+	pick := rand.Intn(totalWeight)
+	for _, candidate := range candidates {
+		pick -= int(candidate.Weight)
+		if pick < 0 {
+			return candidate
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// checkClusterID compares the cluster ID reported by a broker to the expected one. Brokers which
+// don't report a cluster ID can't be verified and are accepted.
+func (s *stanScaler) checkClusterID(endpoint stanEndpoint, serverInfo *monitorServerInfo) error {
+	if s.metadata.expectedClusterID == "" {
+		return nil
+	}
+	if serverInfo == nil || serverInfo.ClusterID == "" {
+		s.logger.Info("Warning: unable to verify the cluster id, the nats streaming broker doesn't report it", "serverzEndpoint", endpoint.serverzEndpoint)
+		return nil
+	}
+	if serverInfo.ClusterID == s.metadata.expectedClusterID {
+		return nil
+	}
+
+	err := fmt.Errorf("nats streaming broker at %s reported cluster id %q, expected %q", endpoint.serverzEndpoint, serverInfo.ClusterID, s.metadata.expectedClusterID)
+	if s.metadata.clusterIDMismatch == stanClusterIDMismatchWarn {
+		s.logger.Info("Warning: "+err.Error(), "serverzEndpoint", endpoint.serverzEndpoint)
+		return nil
+	}
+	s.logger.Error(err, "Skipping nats streaming broker with an unexpected cluster id", "serverzEndpoint", endpoint.serverzEndpoint)
+	return err
+}
+
+// getChannelState combines the state of a subject across the clusters. It returns the number
+// of clusters which answered, and a nil state if the channel doesn't exist on any of them.
+// The subscriber lags and the observation of the subject are only exported when
+// exportObservations is set.
+func (s *stanScaler) getChannelState(ctx context.Context, endpoints []stanEndpoint, subject string, exportObservations bool) (*stanChannelState, int, error) {
+	var state *stanChannelState
+	reachable := 0
+	var lastErr error
+
+	for _, endpoint := range endpoints {
+		channelInfo, err := s.getChannelInfo(ctx, endpoint, subject)
+		channelInfo, err = s.applyStaleTolerance(ctx, endpoint, subject, channelInfo, err, time.Now())
+		if err != nil {
+			lastErr = err
+			if len(s.metadata.endpoints) > 1 {
+				s.logger.Info("Warning: skipping unreachable nats streaming cluster", "stanChannelsEndpoint", endpoint.stanChannelsEndpoint, "error", err.Error())
+			}
+			continue
+		}
+		reachable++
+		if channelInfo == nil {
+			continue
+		}
+		if state == nil {
+			state = &stanChannelState{ackGaps: map[string]int64{}}
+		}
+		lag := s.getMaxMsgLag(channelInfo)
+		s.logger.V(1).Info("Stan scaler: Fetched channel info", "stanChannelsEndpoint", endpoint.stanChannelsEndpoint, "subject", subject,
+			"subscribers", len(channelInfo.Subscriber), "combinedQueueName", s.metadata.durableName+":"+s.metadata.queueGroup,
+			"matchedSubscribers", len(s.getQueueSubscribers(channelInfo)), "lastSequence", channelInfo.LastSequence, "lag", lag)
+		state.lag += lag
+		state.lastSequence += channelInfo.LastSequence
+		state.msgCount += channelInfo.MsgCount
+		if s.metadata.lagMode == stanLagModeAckGapTrend {
+			for _, subscriber := range s.getQueueSubscribers(channelInfo) {
+				state.ackGaps[subject+"/"+subscriber.ClientID] += int64(subscriber.PendingCount)
+			}
+		}
+		state.lastSent += s.getMaxLastSent(channelInfo)
+		state.subscribers += s.getSubscriberCount(channelInfo)
+		state.hasPendingMessage = state.hasPendingMessage || s.hasPendingMessage(channelInfo)
+		state.stalled = state.stalled || s.hasStalledSubscriber(channelInfo)
+		if clientIDs := s.getOfflineClientIDs(channelInfo); clientIDs != nil {
+			offlineLag := channelInfo.LastSequence - s.getMaxLastSent(channelInfo)
+			if offlineLag < 0 {
+				offlineLag = 0
+			}
+			s.logger.Info("Warning: every subscriber of the STAN queue group is offline", "subject", subject, "clientIDs", clientIDs, "lag", offlineLag)
+			state.offlineLag += offlineLag
+		}
+		if len(s.getQueueSubscribers(channelInfo)) == 0 {
+			state.unconsumedSequence += channelInfo.LastSequence
+		}
+		if exportObservations {
+			state.exportedSubscribers = append(state.exportedSubscribers, s.recordSubscriberLags(subject, channelInfo)...)
+		}
+	}
+
+	if exportObservations && state != nil && s.observationSink != nil {
+		s.observationSink.Record(stanObservation{Subject: subject, Lag: state.lag, Timestamp: time.Now()})
+	}
+	return state, reachable, lastErr
+}
+
+// applyStaleTolerance remembers the channel info fetched from a cluster, and serves it in place
+// of an error for staleTolerance after it was fetched, so a brief outage of the monitoring
+// endpoint doesn't fail the poll. Errors answered by the endpoint, such as a rejected token,
+// are never hidden.
+func (s *stanScaler) applyStaleTolerance(ctx context.Context, endpoint stanEndpoint, subject string, channelInfo *monitorChannelInfo, err error, now time.Time) (*monitorChannelInfo, error) {
+	if s.metadata.staleTolerance == 0 {
+		return channelInfo, err
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	key := stanChannelInfoKey{stanChannelsEndpoint: endpoint.stanChannelsEndpoint, subject: subject}
+	if err == nil {
+		if s.channelInfos == nil {
+			s.channelInfos = map[stanChannelInfoKey]stanCachedChannelInfo{}
+		}
+		s.channelInfos[key] = stanCachedChannelInfo{channelInfo: channelInfo, fetchedAt: now}
+		return channelInfo, nil
+	}
+
+	cached, ok := s.channelInfos[key]
+	if !ok || ctx.Err() != nil || !isStanOutageError(err) {
+		return nil, err
+	}
+	age := now.Sub(cached.fetchedAt)
+	if age > s.metadata.staleTolerance {
+		delete(s.channelInfos, key)
+		return nil, err
+	}
+	s.logger.Info("Warning: serving stale channel info, the nats streaming broker is unreachable", "stanChannelsEndpoint", endpoint.stanChannelsEndpoint, "subject", subject, "age", age.String(), "error", err.Error())
+	return cached.channelInfo, nil
+}
+
+// pruneChannelInfos forgets the channel info of the channels no longer listed by the cluster, so
+// the channels deleted under subjectPattern don't accumulate
+func (s *stanScaler) pruneChannelInfos(endpoint stanEndpoint, listed map[string]bool) {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	for key := range s.channelInfos {
+		if key.stanChannelsEndpoint == endpoint.stanChannelsEndpoint && !listed[key.subject] {
+			delete(s.channelInfos, key)
+		}
+	}
+}
+
+// recordSubscriberLags exports the lag of each subscriber of the queue group when subscriberMetrics
+// is set, and returns their client IDs. Subscribers beyond subscriberMetricsLimit aren't exported.
+func (s *stanScaler) recordSubscriberLags(subject string, channelInfo *monitorChannelInfo) []string {
+	if !s.metadata.subscriberMetrics {
+		return nil
+	}
+	var clientIDs []string
+	for _, subscriber := range s.getQueueSubscribers(channelInfo) {
+		lag := float64(channelInfo.LastSequence - subscriber.LastSent)
+		prommetrics.RecordScalerSubscriberLag(s.subscriberGuard, s.metadata.namespace, s.scaledObject, stanScalerType, subject, subscriber.ClientID, lag)
+		clientIDs = append(clientIDs, subscriber.ClientID)
+	}
+	return clientIDs
+}
+
+// pruneSubscriberLags deletes the lags exported for the subscribers which have gone away, keyed by
+// subject and client ID in exported, so their series don't outlive them and their slots under
+// subscriberMetricsLimit are freed
+func (s *stanScaler) pruneSubscriberLags(exported map[string]bool) {
+	if !s.metadata.subscriberMetrics {
+		return
+	}
+	prommetrics.DeleteScalerSubscriberLags(s.subscriberGuard, func(subject string, subscriber string) bool {
+		return exported[subject+"/"+subscriber]
+	})
+}
+
+// getSubjectLagThreshold returns the lag threshold of a subject, falling back to lagThreshold
+func (s *stanScaler) getSubjectLagThreshold(subject string) int64 {
+	if threshold, ok := s.metadata.subjectLagThresholds[subject]; ok {
+		return threshold
+	}
+	return s.metadata.lagThreshold
+}
+
+// sampleObservations counts the polls and returns whether the current one exports the
+// observation metrics, which happens on the first poll and every observationSampleRate polls after
+func (s *stanScaler) sampleObservations() bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	exported := s.observationPolls%int64(s.metadata.observationSampleRate) == 0
+	s.observationPolls++
+	return exported
+}
+
+// recordThreshold exports lagThreshold, or the threshold computed from throughputPerReplica,
+// when exportThreshold is set. It's recorded on each poll so the gauge follows the scaler
+// rebuilt with a new threshold.
+func (s *stanScaler) recordThreshold(metricName string) {
+	if !s.metadata.exportThreshold {
+		return
+	}
+	prommetrics.RecordScalerThreshold(s.metadata.namespace, s.scaledObject, stanScalerType, s.metadata.scalerIndex, metricName, float64(s.metadata.lagThreshold))
+}
+
+// recordFirstPoll measures the time taken by the scaler to get its first successful poll, unless
+// it was already recorded for an earlier scaler of the trigger
+func (s *stanScaler) recordFirstPoll() {
+	s.firstPoll.Do(func() {
+		prommetrics.RecordScalerFirstPollDuration(s.identifier, s.metadata.scalerIndex, stanScalerType, time.Since(s.createdAt))
+	})
+}
+
+// updatePollingInterval doubles the suggested polling interval while there is nothing to
+// consume, up to maxPollingInterval, and goes back to minPollingInterval on activity. The scale
+// loop never waits longer than the pollingInterval of the ScaledObject, whatever the suggestion.
+func (s *stanScaler) updatePollingInterval(idle bool) {
+	if s.metadata.maxPollingInterval == 0 {
+		return
+	}
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if !idle || s.pollingInterval == 0 {
+		s.pollingInterval = s.metadata.minPollingInterval
+		return
+	}
+	s.pollingInterval *= 2
+	if s.pollingInterval > s.metadata.maxPollingInterval {
+		s.pollingInterval = s.metadata.maxPollingInterval
+	}
+}
+
+// SuggestPollingInterval returns the polling interval based on the last observation, or zero
+// when adaptive polling isn't enabled
+func (s *stanScaler) SuggestPollingInterval() time.Duration {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	return s.pollingInterval
+}
+
+// ConfigChecksum returns a hash of the resolved metadata. Credentials in the endpoints are
+// redacted first, so they can't be recovered from the checksum.
+func (s *stanScaler) ConfigChecksum() string {
+	meta := s.metadata
+	meta.endpoints = make([]stanEndpoint, 0, len(s.metadata.endpoints))
+	for _, endpoint := range s.metadata.endpoints {
+		meta.endpoints = append(meta.endpoints, stanEndpoint{
+			stanChannelsEndpoint: redactSTANURL(endpoint.stanChannelsEndpoint),
+			serverzEndpoint:      redactSTANURL(endpoint.serverzEndpoint),
+		})
+	}
+	if meta.bearerToken != "" {
+		meta.bearerToken = stanRedactedCredential
+	}
+	if meta.key != "" {
+		meta.key = stanRedactedCredential
+	}
+	return GetConfigChecksum(meta)
+}
+
+// redactSTANURL replaces the password of a monitoring URL, if any
+func redactSTANURL(monitoringURL string) string {
+	u, err := url.Parse(monitoringURL)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// logDryRunRecommendation logs the replica count the metric would lead to. With a Value
+// target the HPA scales the current replica count, which the scaler doesn't know, by the
+// ratio of the metric to the target, so the ratio is logged instead.
+func (s *stanScaler) logDryRunRecommendation(lag int64, metricValue float64) {
+	target := float64(s.metadata.lagThreshold)
+	if s.metricType == v2.AverageValueMetricType {
+		s.logger.Info("Stan scaler: Dry-run, would scale to", "lag", lag, "metricValue", metricValue, "target", target, "impliedReplicas", GetImpliedReplicaCount(metricValue, target))
+		return
+	}
+	s.logger.Info("Stan scaler: Dry-run, would scale the current replicas by", "lag", lag, "metricValue", metricValue, "target", target, "impliedReplicaRatio", metricValue/target)
+}
+
+// MetricType returns the target type of the metric, Value or AverageValue
+func (s *stanScaler) MetricType() v2.MetricTargetType {
+	return s.metricType
+}
+
+// IsTrusted returns whether the scaler had enough successful polls since its last failure
+func (s *stanScaler) IsTrusted() bool {
+	return s.metadata.minSuccessesBeforeTrust == 0 || s.healthTracker.IsTrusted()
+}
+
+// holdMetricValue keeps the metric from dropping below the last trusted value while
+// the scaler recovers from failures
+func (s *stanScaler) holdMetricValue(metricValue float64) float64 {
+	if s.metadata.minSuccessesBeforeTrust == 0 {
+		return metricValue
+	}
+
+	return s.healthTracker.HoldValue(metricValue)
+}
+
+// Ping checks that a monitoring endpoint answers on its base channelsz endpoint, without
+// querying any channel. It returns nil as soon as an endpoint responds with a 200.
+func (s *stanScaler) Ping(ctx context.Context) error {
+	endpoints, err := s.getEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fmt.Errorf("no nats streaming monitoring endpoint configured")
+	for _, endpoint := range endpoints {
+		if err = s.pingEndpoint(ctx, endpoint); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// pingEndpoint issues a single request to the base channelsz endpoint, without retries
+func (s *stanScaler) pingEndpoint(ctx context.Context, endpoint stanEndpoint) error {
+	ctx, cancel := s.withRequestTimeout(ctx)
+	defer cancel()
+	req, err := s.newMonitoringRequest(ctx, endpoint.stanChannelsEndpoint)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error pinging the nats streaming monitoring endpoint %s: %w", redactSTANURL(endpoint.stanChannelsEndpoint), categorizeStanRequestError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error pinging the nats streaming monitoring endpoint %s: %w", redactSTANURL(endpoint.stanChannelsEndpoint), newStanStatusError("channelsz", resp.StatusCode))
+	}
+	return nil
+}
+
+// Close deletes the exported subscriber lags and releases the client. The idle connections to
+// the monitoring endpoints are only closed when no other scaler shares the client.
+func (s *stanScaler) Close(context.Context) error {
+	if s.metadata.subscriberMetrics && s.subscriberGuard != nil {
+		prommetrics.DeleteScalerSubscriberLags(s.subscriberGuard, nil)
+	}
+	s.releaseClient.Do(func() {
+		if s.httpClient != nil && releaseStanHTTPClient(s.httpClientKey) {
+			s.httpClient.CloseIdleConnections()
+		}
+	})
 	return nil
 }
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"time"
 
 	v2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
@@ -68,6 +69,29 @@ func (c *ScalersCache) GetPushScalers() []scalers.PushScaler {
 	return result
 }
 
+// GetSuggestedPollingInterval returns the shortest polling interval suggested by the scalers,
+// capped at defaultInterval, the pollingInterval of the scalable object, so an idle scaler backing
+// off doesn't delay the scaling from zero. As the scale loop polls every scaler at once,
+// defaultInterval is kept as soon as one scaler doesn't support adaptive polling or makes no
+// suggestion.
+func (c *ScalersCache) GetSuggestedPollingInterval(defaultInterval time.Duration) time.Duration {
+	interval := defaultInterval
+	for _, s := range c.Scalers {
+		ps, ok := s.Scaler.(scalers.AdaptivePollingScaler)
+		if !ok {
+			return defaultInterval
+		}
+		suggested := ps.SuggestPollingInterval()
+		if suggested <= 0 {
+			return defaultInterval
+		}
+		if suggested < interval {
+			interval = suggested
+		}
+	}
+	return interval
+}
+
 // GetMetricsForScaler returns metric value for a scaler identified by the metric name
 // and by the input index (from the list of scalers in this ScaledObject)
 func (c *ScalersCache) GetMetricsForScaler(ctx context.Context, index int, metricName string) ([]external_metrics.ExternalMetricValue, error) {
@@ -161,6 +185,10 @@ func (c *ScalersCache) GetScaledObjectState(ctx context.Context, scaledObject *k
 				if spec.Resource != nil {
 					logger.V(1).Info("Scaler for scaledObject is active", "Metrics Name", spec.Resource.Name)
 				}
+			} else if hs, ok := c.Scalers[i].Scaler.(scalers.HealthReportingScaler); ok && !hs.IsTrusted() {
+				// avoid deactivating on metrics from a scaler that has been failing recently
+				isScaledObjectActive = true
+				logger.V(1).Info("Scaler for scaledObject isn't trusted after recent failures, keeping it active", "Metrics Name", spec.External.Metric.Name)
 			}
 		}
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -278,3 +279,70 @@ func createScaler(ctrl *gomock.Controller, queueLength int64, averageValue int64
 	scaler.EXPECT().Close(gomock.Any())
 	return scaler
 }
+
+type healthReportingScaler struct {
+	*mock_scalers.MockScaler
+	trusted bool
+}
+
+func (s healthReportingScaler) IsTrusted() bool {
+	return s.trusted
+}
+
+func TestGetScaledObjectStateWithUntrustedScaler(t *testing.T) {
+	metricName := "s0-queueLength"
+	ctrl := gomock.NewController(t)
+	recorder := record.NewFakeRecorder(1)
+
+	for _, trusted := range []bool{true, false} {
+		scaler := mock_scalers.NewMockScaler(ctrl)
+		scaler.EXPECT().GetMetricSpecForScaling(gomock.Any()).Return([]v2.MetricSpec{createMetricSpec(2, metricName)})
+		scaler.EXPECT().GetMetricsAndActivity(gomock.Any(), gomock.Any()).Return([]external_metrics.ExternalMetricValue{}, false, nil)
+
+		cache := ScalersCache{
+			Scalers:  []ScalerBuilder{{Scaler: healthReportingScaler{scaler, trusted}}},
+			Recorder: recorder,
+		}
+
+		scaledObject := &kedav1alpha1.ScaledObject{Spec: kedav1alpha1.ScaledObjectSpec{ScaleTargetRef: &kedav1alpha1.ScaleTarget{Name: "test"}}}
+		isActive, isError, _ := cache.GetScaledObjectState(context.TODO(), scaledObject)
+		assert.Equal(t, !trusted, isActive)
+		assert.False(t, isError)
+	}
+}
+
+type adaptivePollingScaler struct {
+	*mock_scalers.MockScaler
+	interval time.Duration
+}
+
+func (s adaptivePollingScaler) SuggestPollingInterval() time.Duration {
+	return s.interval
+}
+
+func TestGetSuggestedPollingInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defaultInterval := 30 * time.Second
+
+	adaptive := func(interval time.Duration) ScalerBuilder {
+		return ScalerBuilder{Scaler: adaptivePollingScaler{MockScaler: mock_scalers.NewMockScaler(ctrl), interval: interval}}
+	}
+
+	tests := []struct {
+		name     string
+		scalers  []ScalerBuilder
+		expected time.Duration
+	}{
+		{"no adaptive scaler", []ScalerBuilder{{Scaler: mock_scalers.NewMockScaler(ctrl)}}, defaultInterval},
+		{"adaptive next to a non-adaptive scaler", []ScalerBuilder{adaptive(2 * time.Minute), {Scaler: mock_scalers.NewMockScaler(ctrl)}}, defaultInterval},
+		{"shorter suggestion next to a non-adaptive scaler", []ScalerBuilder{adaptive(10 * time.Second), {Scaler: mock_scalers.NewMockScaler(ctrl)}}, defaultInterval},
+		{"no suggestion", []ScalerBuilder{adaptive(0), adaptive(10 * time.Second)}, defaultInterval},
+		{"shortest suggestion", []ScalerBuilder{adaptive(2 * time.Minute), adaptive(10 * time.Second)}, 10 * time.Second},
+		{"backoff capped at the default", []ScalerBuilder{adaptive(2 * time.Minute)}, defaultInterval},
+	}
+
+	for _, test := range tests {
+		cache := ScalersCache{Scalers: test.scalers}
+		assert.Equal(t, test.expected, cache.GetSuggestedPollingInterval(defaultInterval), test.name)
+	}
+}
@@ -44,6 +44,7 @@ import (
 	"github.com/kedacore/keda/v2/pkg/scaling/cache/metricscache"
 	"github.com/kedacore/keda/v2/pkg/scaling/executor"
 	"github.com/kedacore/keda/v2/pkg/scaling/resolver"
+	kedautil "github.com/kedacore/keda/v2/pkg/util"
 )
 
 // ScaleHandler encapsulates the logic of calling the right scalers for
@@ -68,6 +69,8 @@ type scaleHandler struct {
 	scalerCachesLock         *sync.RWMutex
 	scaledObjectsMetricCache metricscache.MetricsCache
 	secretsLister            corev1listers.SecretLister
+	// dryRun keeps the scale loop from activating or deactivating the workloads
+	dryRun bool
 }
 
 // NewScaleHandler creates a ScaleHandler object
@@ -83,6 +86,7 @@ func NewScaleHandler(client client.Client, scaleClient scale.ScalesGetter, recon
 		scalerCachesLock:         &sync.RWMutex{},
 		scaledObjectsMetricCache: metricscache.NewMetricsCache(),
 		secretsLister:            secretsLister,
+		dryRun:                   kedautil.IsDryRun(),
 	}
 }
 
@@ -142,6 +146,7 @@ func (h *scaleHandler) DeleteScalableObject(ctx context.Context, scalableObject
 		if err != nil {
 			h.logger.Error(err, "error clearing scalers cache")
 		}
+		prommetrics.ForgetScalerFirstPolls(key)
 		h.recorder.Event(withTriggers, corev1.EventTypeNormal, eventreason.KEDAScalersStopped, "Stopped scalers watch")
 	} else {
 		h.logger.V(1).Info("ScaledObject was not found in controller cache", "key", key)
@@ -158,8 +163,9 @@ func (h *scaleHandler) startScaleLoop(ctx context.Context, withTriggers *kedav1a
 	logger.V(1).Info("Watching with pollingInterval", "PollingInterval", pollingInterval)
 
 	for {
-		tmr := time.NewTimer(pollingInterval)
+		start := time.Now()
 		h.checkScalers(ctx, scalableObject, scalingMutex)
+		tmr := time.NewTimer(h.getNextPollingInterval(ctx, scalableObject, pollingInterval) - time.Since(start))
 
 		select {
 		case <-tmr.C:
@@ -176,6 +182,16 @@ func (h *scaleHandler) startScaleLoop(ctx context.Context, withTriggers *kedav1a
 	}
 }
 
+// getNextPollingInterval returns the polling interval suggested by the scalers of the scalableObject,
+// falling back to its pollingInterval
+func (h *scaleHandler) getNextPollingInterval(ctx context.Context, scalableObject interface{}, pollingInterval time.Duration) time.Duration {
+	cache, err := h.GetScalersCache(ctx, scalableObject)
+	if err != nil {
+		return pollingInterval
+	}
+	return cache.GetSuggestedPollingInterval(pollingInterval)
+}
+
 // GetScalersCache returns cache for input scalableObject, if the object is not found in the cache, it returns a new one
 // if the input object is ScaledObject, it also compares the Generation of the input of object with the one stored in the cache,
 // this is needed for out of scalerLoop invocations of this method (in package `controllers/keda`).
@@ -354,7 +370,11 @@ func (h *scaleHandler) checkScalers(ctx context.Context, scalableObject interfac
 			return
 		}
 		isActive, isError, metricsRecords := cache.GetScaledObjectState(ctx, obj)
-		h.scaleExecutor.RequestScale(ctx, obj, isActive, isError)
+		if h.dryRun {
+			h.logger.Info("Dry-run mode, not scaling scaledObject", "scaledObject.Namespace", obj.Namespace, "scaledObject.Name", obj.Name, "isActive", isActive, "isError", isError)
+		} else {
+			h.scaleExecutor.RequestScale(ctx, obj, isActive, isError)
+		}
 		if len(metricsRecords) > 0 {
 			h.logger.V(1).Info("Storing metrics to cache", "scaledObject.Namespace", obj.Namespace, "scaledObject.Name", obj.Name, "metricsRecords", metricsRecords)
 			h.scaledObjectsMetricCache.StoreRecords(obj.GenerateIdentifier(), metricsRecords)
@@ -366,7 +386,11 @@ func (h *scaleHandler) checkScalers(ctx context.Context, scalableObject interfac
 			return
 		}
 		isActive, scaleTo, maxScale := cache.IsScaledJobActive(ctx, obj)
-		h.scaleExecutor.RequestJobScale(ctx, obj, isActive, scaleTo, maxScale)
+		if h.dryRun {
+			h.logger.Info("Dry-run mode, not scaling scaledJob", "scaledJob.Namespace", obj.Namespace, "scaledJob.Name", obj.Name, "isActive", isActive, "scaleTo", scaleTo, "maxScale", maxScale)
+		} else {
+			h.scaleExecutor.RequestJobScale(ctx, obj, isActive, scaleTo, maxScale)
+		}
 	}
 }
 
@@ -501,6 +525,9 @@ func (h *scaleHandler) buildScalers(ctx context.Context, withTriggers *kedav1alp
 
 	for i, t := range withTriggers.Spec.Triggers {
 		triggerIndex, trigger := i, t
+		healthTracker := scalers.NewHealthTracker()
+		// the scalers set the limit of the guard from their metadata
+		cardinalityGuard := prommetrics.NewCardinalityGuard(0)
 
 		factory := func() (scalers.Scaler, *scalers.ScalerConfig, error) {
 			if podTemplateSpec != nil {
@@ -521,6 +548,8 @@ func (h *scaleHandler) buildScalers(ctx context.Context, withTriggers *kedav1alp
 				GlobalHTTPTimeout:       h.globalHTTPTimeout,
 				ScalerIndex:             triggerIndex,
 				MetricType:              trigger.MetricType,
+				HealthTracker:           healthTracker,
+				CardinalityGuard:        cardinalityGuard,
 			}
 
 			authParams, podIdentity, err := resolver.ResolveAuthRefAndPodIdentity(ctx, h.client, logger, trigger.AuthenticationRef, podTemplateSpec, withTriggers.Namespace, h.secretsLister)
@@ -546,6 +575,10 @@ func (h *scaleHandler) buildScalers(ctx context.Context, withTriggers *kedav1alp
 			return nil, err
 		}
 
+		if checksumScaler, ok := scaler.(scalers.ConfigChecksumScaler); ok {
+			logger.Info("Built scaler", "scalerIndex", triggerIndex, "configChecksum", checksumScaler.ConfigChecksum())
+		}
+
 		result = append(result, cache.ScalerBuilder{
 			Scaler:       scaler,
 			ScalerConfig: *config,
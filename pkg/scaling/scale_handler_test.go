@@ -134,6 +134,83 @@ func TestGetScaledObjectMetrics_DirectCall(t *testing.T) {
 	scalerCache.Close(context.Background())
 }
 
+func TestCheckScalersDryRun(t *testing.T) {
+	metricName := "test-metric-name"
+
+	ctrl := gomock.NewController(t)
+	recorder := record.NewFakeRecorder(1)
+	mockClient := mock_client.NewMockClient(ctrl)
+	mockStatusWriter := mock_client.NewMockStatusWriter(ctrl)
+	// no scaling is expected from the executor
+	mockExecutor := mock_executor.NewMockScaleExecutor(ctrl)
+
+	metricsSpecs := []v2.MetricSpec{createMetricSpec(10, metricName)}
+	metricValue := scalers.GenerateMetricInMili(metricName, float64(10))
+
+	scaler := mock_scalers.NewMockScaler(ctrl)
+	scalerConfig := scalers.ScalerConfig{}
+	factory := func() (scalers.Scaler, *scalers.ScalerConfig, error) {
+		return scaler, &scalerConfig, nil
+	}
+
+	scaledObject := kedav1alpha1.ScaledObject{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "testName",
+			Namespace: "testNamespace",
+		},
+		Spec: kedav1alpha1.ScaledObjectSpec{
+			ScaleTargetRef: &kedav1alpha1.ScaleTarget{
+				Name: "test",
+			},
+		},
+	}
+
+	scalerCache := cache.ScalersCache{
+		ScaledObject: &scaledObject,
+		Scalers: []cache.ScalerBuilder{{
+			Scaler:       scaler,
+			ScalerConfig: scalerConfig,
+			Factory:      factory,
+		}},
+		Recorder: recorder,
+	}
+
+	caches := map[string]*cache.ScalersCache{}
+	caches[scaledObject.GenerateIdentifier()] = &scalerCache
+
+	sh := scaleHandler{
+		client:                   mockClient,
+		logger:                   logr.Discard(),
+		scaleLoopContexts:        &sync.Map{},
+		scaleExecutor:            mockExecutor,
+		globalHTTPTimeout:        time.Duration(1000),
+		recorder:                 recorder,
+		scalerCaches:             caches,
+		scalerCachesLock:         &sync.RWMutex{},
+		scaledObjectsMetricCache: metricscache.NewMetricsCache(),
+		dryRun:                   true,
+	}
+
+	mockClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	scaler.EXPECT().GetMetricSpecForScaling(gomock.Any()).Return(metricsSpecs)
+	scaler.EXPECT().GetMetricsAndActivity(gomock.Any(), gomock.Any()).Return([]external_metrics.ExternalMetricValue{metricValue}, true, nil)
+	sh.checkScalers(context.TODO(), &scaledObject, &sync.RWMutex{})
+
+	// the HPA still gets the metrics, so the dry run doesn't surface as failed metrics
+	mockClient.EXPECT().Status().Return(mockStatusWriter)
+	mockStatusWriter.EXPECT().Patch(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	scaler.EXPECT().GetMetricSpecForScaling(gomock.Any()).Return(metricsSpecs)
+	scaler.EXPECT().GetMetricsAndActivity(gomock.Any(), gomock.Any()).Return([]external_metrics.ExternalMetricValue{metricValue}, true, nil)
+	metrics, _, err := sh.GetScaledObjectMetrics(context.TODO(), scaledObject.Name, scaledObject.Namespace, metricName)
+	assert.NoError(t, err)
+	if assert.NotNil(t, metrics) {
+		assert.Len(t, metrics.Items, 1)
+	}
+
+	scaler.EXPECT().Close(gomock.Any())
+	scalerCache.Close(context.Background())
+}
+
 func TestGetScaledObjectMetrics_FromCache(t *testing.T) {
 	scaledObjectName := "testName2"
 	scaledObjectNamespace := "testNamespace2"
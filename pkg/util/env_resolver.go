@@ -24,8 +24,18 @@ import (
 
 const RestrictSecretAccessEnvVar = "KEDA_RESTRICT_SECRET_ACCESS"
 
+// DryRunEnvVar enables dry-run mode: scalers log the scaling they would recommend and KEDA doesn't
+// activate or deactivate the workloads. The metrics are still provided to the HPA.
+const DryRunEnvVar = "KEDA_DRY_RUN"
+
 var clusterObjectNamespaceCache *string
 
+var dryRun bool
+
+func init() {
+	dryRun = resolveDryRun()
+}
+
 func ResolveOsEnvBool(envName string, defaultValue bool) (bool, error) {
 	valueStr, found := os.LookupEnv(envName)
 
@@ -81,3 +91,18 @@ func GetClusterObjectNamespace() (string, error) {
 func GetRestrictSecretAccess() string {
 	return os.Getenv(RestrictSecretAccessEnvVar)
 }
+
+// IsDryRun returns true when dry-run mode was enabled through the KEDA_DRY_RUN environment
+// variable, which is resolved once at startup
+func IsDryRun() bool {
+	return dryRun
+}
+
+// resolveDryRun reads KEDA_DRY_RUN. An unparsable value is treated as disabled.
+func resolveDryRun() bool {
+	enabled, err := ResolveOsEnvBool(DryRunEnvVar, false)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
@@ -41,3 +41,14 @@ func TestResolveValidOsEnvDuration(t *testing.T) {
 	assert.Equal(t, time.Duration(30)*time.Minute, *actual)
 	assert.Nil(t, err)
 }
+
+func TestResolveDryRun(t *testing.T) {
+	t.Setenv(DryRunEnvVar, "")
+	assert.False(t, resolveDryRun())
+
+	t.Setenv(DryRunEnvVar, "true")
+	assert.True(t, resolveDryRun())
+
+	t.Setenv(DryRunEnvVar, "not-a-bool")
+	assert.False(t, resolveDryRun())
+}
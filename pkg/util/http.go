@@ -17,12 +17,27 @@ limitations under the License.
 package util
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var disableKeepAlives bool
+var propagateTraceContext bool
+
+// the idle connections kept by the clients, which are created for every scaler
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+)
 
 func init() {
 	var err error
@@ -30,6 +45,10 @@ func init() {
 	if err != nil {
 		disableKeepAlives = false
 	}
+	propagateTraceContext, err = ResolveOsEnvBool("KEDA_HTTP_PROPAGATE_TRACE_CONTEXT", false)
+	if err != nil {
+		propagateTraceContext = false
+	}
 }
 
 // HTTPDoer is an interface that matches the Do method on
@@ -39,26 +58,148 @@ type HTTPDoer interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
+// HTTPClientOption customizes the transport of a client created by CreateHTTPClient
+type HTTPClientOption func(*http.Transport)
+
+// WithResponseHeaderTimeout bounds the time waiting for the response headers once the request
+// is written, so hung servers fail before the client timeout
+func WithResponseHeaderTimeout(timeout time.Duration) HTTPClientOption {
+	return func(transport *http.Transport) {
+		transport.ResponseHeaderTimeout = timeout
+	}
+}
+
+// WithExpectContinueTimeout bounds the time waiting for the first response headers of a request
+// sending "Expect: 100-continue" before its body is sent anyway
+func WithExpectContinueTimeout(timeout time.Duration) HTTPClientOption {
+	return func(transport *http.Transport) {
+		transport.ExpectContinueTimeout = timeout
+	}
+}
+
+// WithTLSConfig replaces the TLS configuration of the transport, for instance to trust a
+// private CA or to present a client certificate
+func WithTLSConfig(config *tls.Config) HTTPClientOption {
+	return func(transport *http.Transport) {
+		transport.TLSClientConfig = config
+	}
+}
+
+// WithUnixSocket dials the unix domain socket at socketPath for every request, whatever the
+// host of the request URL. Requests don't go through the proxy.
+func WithUnixSocket(socketPath string) HTTPClientOption {
+	return func(transport *http.Transport) {
+		dialer := &net.Dialer{}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		transport.Proxy = nil
+	}
+}
+
+// WithIdleConnLimits replaces the default limits of the idle connections kept by the transport
+func WithIdleConnLimits(maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) HTTPClientOption {
+	return func(transport *http.Transport) {
+		transport.MaxIdleConns = maxIdleConns
+		transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		transport.IdleConnTimeout = idleConnTimeout
+	}
+}
+
 // CreateHTTPClient returns a new HTTP client with the timeout set to
 // timeoutMS milliseconds, or 300 milliseconds if timeoutMS <= 0.
-// unsafeSsl parameter allows to avoid tls cert validation if it's required
-func CreateHTTPClient(timeout time.Duration, unsafeSsl bool) *http.Client {
+// unsafeSsl parameter allows to avoid tls cert validation if it's required.
+// Requests go through the proxy defined by the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables. At most
+// defaultMaxIdleConns idle connections are kept, defaultMaxIdleConnsPerHost of
+// them to the same host, for defaultIdleConnTimeout.
+func CreateHTTPClient(timeout time.Duration, unsafeSsl bool, options ...HTTPClientOption) *http.Client {
 	// default the timeout to 300ms
 	if timeout <= 0 {
 		timeout = 300 * time.Millisecond
 	}
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: unsafeSsl},
-		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: unsafeSsl},
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        defaultMaxIdleConns,
+		MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     defaultIdleConnTimeout,
 	}
 	if disableKeepAlives {
 		// disable keep http connection alive
 		transport.DisableKeepAlives = true
 		transport.IdleConnTimeout = 100 * time.Second
 	}
+	for _, option := range options {
+		option(transport)
+	}
 	httpClient := &http.Client{
 		Timeout:   timeout,
 		Transport: transport,
 	}
+	if propagateTraceContext {
+		httpClient.Transport = NewTraceContextTransport(transport)
+	}
 	return httpClient
 }
+
+// traceContextTransport adds the W3C traceparent header of the span in the request context
+type traceContextTransport struct {
+	base http.RoundTripper
+}
+
+// NewTraceContextTransport wraps base to propagate the W3C trace context of the requests,
+// when their context carries a span
+func NewTraceContextTransport(base http.RoundTripper) http.RoundTripper {
+	return &traceContextTransport{base: base}
+}
+
+func (t *traceContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.SpanContextFromContext(req.Context()).IsValid() {
+		return t.base.RoundTrip(req)
+	}
+	// a RoundTripper must not modify the request it's given
+	req = req.Clone(req.Context())
+	propagation.TraceContext{}.Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	return t.base.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the base transport, so that
+// http.Client.CloseIdleConnections reaches it through the wrapper
+func (t *traceContextTransport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if base, ok := t.base.(closeIdler); ok {
+		base.CloseIdleConnections()
+	}
+}
+
+// CheckResponseContentType returns an error if the Content-Type of the response doesn't
+// match any of the allowed media types. Parameters such as charset are ignored. Responses
+// without a Content-Type header are rejected unless allowMissing is set.
+func CheckResponseContentType(resp *http.Response, allowedContentTypes []string, allowMissing bool) error {
+	if len(allowedContentTypes) == 0 {
+		return nil
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		if allowMissing {
+			return nil
+		}
+		return fmt.Errorf("unexpected response content type: missing Content-Type header, expected one of %v", allowedContentTypes)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid response content type %q: %s", contentType, err)
+	}
+	for _, allowed := range allowedContentTypes {
+		if mediaType == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unexpected response content type %q, expected one of %v", mediaType, allowedContentTypes)
+}
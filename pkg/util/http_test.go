@@ -0,0 +1,217 @@
+/*
+Copyright 2022 The KEDA Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestCheckResponseContentType(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		allowed      []string
+		allowMissing bool
+		isError      bool
+	}{
+		{"json", "application/json", []string{"application/json"}, false, false},
+		{"json with charset", "application/json; charset=utf-8", []string{"application/json"}, false, false},
+		{"html", "text/html", []string{"application/json"}, false, true},
+		{"second allowed type", "text/plain", []string{"application/json", "text/plain"}, false, false},
+		{"missing content type", "", []string{"application/json"}, false, true},
+		{"missing content type allowed", "", []string{"application/json"}, true, false},
+		{"no allowlist", "text/html", nil, false, false},
+		{"malformed content type", "application/json;;", []string{"application/json"}, false, true},
+	}
+
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.contentType != "" {
+			resp.Header.Set("Content-Type", test.contentType)
+		}
+		err := CheckResponseContentType(resp, test.allowed, test.allowMissing)
+		if test.isError && err == nil {
+			t.Errorf("%s: expected error but got success", test.name)
+		} else if !test.isError && err != nil {
+			t.Errorf("%s: expected success but got error: %s", test.name, err)
+		}
+	}
+}
+
+func TestTraceContextTransport(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected string
+	}{
+		{"with a span", trace.ContextWithRemoteSpanContext(context.Background(), spanContext), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{"without a span", context.Background(), ""},
+	}
+
+	client := &http.Client{Transport: NewTraceContextTransport(http.DefaultTransport)}
+	for _, test := range tests {
+		traceparent = ""
+		req, err := http.NewRequestWithContext(test.ctx, "GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if traceparent != test.expected {
+			t.Errorf("%s: expected traceparent %q, got %q", test.name, test.expected, traceparent)
+		}
+		if req.Header.Get("traceparent") != "" {
+			t.Errorf("%s: the original request was modified", test.name)
+		}
+	}
+}
+
+func TestCreateHTTPClientTracePropagation(t *testing.T) {
+	defer func(enabled bool) { propagateTraceContext = enabled }(propagateTraceContext)
+
+	propagateTraceContext = false
+	if _, ok := CreateHTTPClient(0, false).Transport.(*traceContextTransport); ok {
+		t.Error("trace context must not be propagated when disabled")
+	}
+	propagateTraceContext = true
+	if _, ok := CreateHTTPClient(0, false).Transport.(*traceContextTransport); !ok {
+		t.Error("trace context must be propagated when enabled")
+	}
+}
+
+func TestCreateHTTPClientOptions(t *testing.T) {
+	defer func(enabled bool) { propagateTraceContext = enabled }(propagateTraceContext)
+	propagateTraceContext = false
+
+	transport := CreateHTTPClient(0, false).Transport.(*http.Transport)
+	if transport.ResponseHeaderTimeout != 0 || transport.ExpectContinueTimeout != 0 {
+		t.Error("timeouts must not be set without options")
+	}
+
+	transport = CreateHTTPClient(0, false, WithResponseHeaderTimeout(2*time.Second), WithExpectContinueTimeout(time.Second)).Transport.(*http.Transport)
+	if transport.ResponseHeaderTimeout != 2*time.Second {
+		t.Errorf("expected a response header timeout of 2s, got %s", transport.ResponseHeaderTimeout)
+	}
+	if transport.ExpectContinueTimeout != time.Second {
+		t.Errorf("expected an expect continue timeout of 1s, got %s", transport.ExpectContinueTimeout)
+	}
+
+	tlsConfig := &tls.Config{ServerName: "stan"}
+	transport = CreateHTTPClient(0, false, WithTLSConfig(tlsConfig)).Transport.(*http.Transport)
+	if transport.TLSClientConfig != tlsConfig {
+		t.Error("expected the tls config to be replaced")
+	}
+}
+
+func TestCreateHTTPClientIdleConnLimits(t *testing.T) {
+	defer func(enabled bool) { propagateTraceContext = enabled }(propagateTraceContext)
+	propagateTraceContext = false
+	defer func(disabled bool) { disableKeepAlives = disabled }(disableKeepAlives)
+	disableKeepAlives = false
+
+	transport := CreateHTTPClient(0, false).Transport.(*http.Transport)
+	if transport.MaxIdleConns != defaultMaxIdleConns || transport.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost || transport.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Errorf("expected the default idle connection limits, got %d, %d and %s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	transport = CreateHTTPClient(0, false, WithIdleConnLimits(20, 2, 30*time.Second)).Transport.(*http.Transport)
+	if transport.MaxIdleConns != 20 || transport.MaxIdleConnsPerHost != 2 || transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("expected the configured idle connection limits, got %d, %d and %s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+}
+
+func TestCreateHTTPClientUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "monitor.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal("Could not listen on the unix socket:", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	transport := CreateHTTPClient(time.Second, false, WithUnixSocket(socketPath)).Transport
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("http://placeholder/path")
+	if err != nil {
+		t.Fatal("Could not reach the unix socket:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status 204, got %d", resp.StatusCode)
+	}
+}
+
+func TestCreateHTTPClientProxy(t *testing.T) {
+	defer func(enabled bool) { propagateTraceContext = enabled }(propagateTraceContext)
+	propagateTraceContext = false
+
+	transport := CreateHTTPClient(0, false).Transport.(*http.Transport)
+	if transport.Proxy == nil {
+		t.Fatal("expected the transport to use a proxy function")
+	}
+	if reflect.ValueOf(transport.Proxy).Pointer() != reflect.ValueOf(http.ProxyFromEnvironment).Pointer() {
+		t.Error("expected the transport to use the proxy of the environment")
+	}
+}
+
+type closeIdleRecorder struct {
+	http.RoundTripper
+	closed bool
+}
+
+func (r *closeIdleRecorder) CloseIdleConnections() {
+	r.closed = true
+}
+
+func TestTraceContextTransportCloseIdleConnections(t *testing.T) {
+	base := &closeIdleRecorder{RoundTripper: http.DefaultTransport}
+	client := &http.Client{Transport: NewTraceContextTransport(base)}
+	client.CloseIdleConnections()
+	if !base.closed {
+		t.Error("expected the idle connections of the base transport to be closed")
+	}
+}